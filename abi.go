@@ -0,0 +1,24 @@
+package gobergamot
+
+import (
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+// ErrIncompatibleWASM is matched by WASMABIError returned for a Bergamot module set with Config.WASM
+// which does not provide what the library relies on.
+var ErrIncompatibleWASM = wasm.ErrIncompatibleModule
+
+// WASMABIError lists all differences found between a Bergamot module and the interface the library relies on:
+// imports not matching host functions, missing or mismatched exports and missing embind classes and methods.
+// Imports and exports are checked by NewEngine, embind classes are registered by the module upon
+// instantiation, so they are checked upon Translator creation.
+type WASMABIError = wasm.ABIError
+
+// ABIMismatch is a single difference reported by WASMABIError.
+type ABIMismatch = wasm.ABIMismatch
+
+// EmbeddedWASM returns Bergamot module embedded into the library, which is used if Config.WASM is nil.
+// The returned slice must not be modified.
+func EmbeddedWASM() []byte {
+	return wasm.BergamotWASM()
+}
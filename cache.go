@@ -0,0 +1,125 @@
+package gobergamot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+const (
+	// cacheEntryPrefix starts names of Config.CacheDir entries created by gobergamot.
+	cacheEntryPrefix = "bergamot-"
+	// customCacheEntryPrefix starts names of entries for modules set with Config.WASM
+	customCacheEntryPrefix = cacheEntryPrefix + "custom-"
+	// customCacheEntryMaxAge is the time after the last use, after which an entry of a module
+	// set with Config.WASM is removed
+	customCacheEntryMaxAge = 30 * 24 * time.Hour
+)
+
+// DefaultCacheDir returns directory used to keep compiled Bergamot module if Config.CacheDir is empty.
+// It is gobergamot directory under os.UserCacheDir, or an empty string if the latter is unknown.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gobergamot")
+}
+
+// embeddedCacheEntryName returns name of the cache directory entry for the embedded WASM module compiled
+// by the linked wazero version. Compiled code of other versions can not be reused, so such entries
+// are stale.
+var embeddedCacheEntryName = sync.OnceValue(func() string {
+	return cacheEntryPrefix + wasmHash(wasm.BergamotWASM()) + wazeroVersionSuffix()
+})
+
+// cacheEntryName returns name of the cache directory entry for the WASM module, which is the embedded one if nil.
+func cacheEntryName(wasmBytes []byte) string {
+	if wasmBytes == nil {
+		return embeddedCacheEntryName()
+	}
+	return customCacheEntryPrefix + wasmHash(wasmBytes) + wazeroVersionSuffix()
+}
+
+func wasmHash(wasmBytes []byte) string {
+	hash := sha256.Sum256(wasmBytes)
+	return hex.EncodeToString(hash[:8])
+}
+
+func wazeroVersionSuffix() string {
+	return "-wazero-" + wazeroVersion()
+}
+
+func wazeroVersion() string {
+	const wazeroPath = "github.com/tetratelabs/wazero"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != wazeroPath {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		return strings.ReplaceAll(dep.Version, string(filepath.Separator), "_")
+	}
+	return "unknown"
+}
+
+// newCompilationCache creates compilation cache of the WASM module (the embedded one if nil) persisted in cacheDir (DefaultCacheDir if empty)
+// and removes stale entries from it. If the directory can not be used, in-memory cache is returned.
+func newCompilationCache(cacheDir string, wasmBytes []byte) wazero.CompilationCache {
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir()
+	}
+	if cacheDir == "" {
+		return wazero.NewCompilationCache()
+	}
+
+	entry := filepath.Join(cacheDir, cacheEntryName(wasmBytes))
+	// modification time of the entry is the time of its last use, see removeStaleCacheEntries
+	now := time.Now()
+	_ = os.Chtimes(entry, now, now)
+	removeStaleCacheEntries(cacheDir, now)
+	cache, err := wazero.NewCompilationCacheWithDir(entry)
+	if err != nil {
+		return wazero.NewCompilationCache()
+	}
+	return cache
+}
+
+// removeStaleCacheEntries removes cache entries created for other embedded WASM modules or wazero versions.
+// Entries of modules set with Config.WASM are removed if they are compiled by other wazero versions
+// or have not been used for customCacheEntryMaxAge, since the library can not tell if they are still needed.
+// Other files in cacheDir are left intact, so it can be shared with other applications.
+func removeStaleCacheEntries(cacheDir string, now time.Time) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	current, suffix := embeddedCacheEntryName(), wazeroVersionSuffix()
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, cacheEntryPrefix) || name == current {
+			continue
+		}
+		if strings.HasPrefix(name, customCacheEntryPrefix) && strings.HasSuffix(name, suffix) {
+			info, err := entry.Info()
+			if err != nil || now.Sub(info.ModTime()) < customCacheEntryMaxAge {
+				continue
+			}
+		}
+		_ = os.RemoveAll(filepath.Join(cacheDir, name))
+	}
+}
@@ -0,0 +1,267 @@
+package gobergamot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxShadows is the number of shadow translations CanaryRouter runs at the same time
+// if CanaryConfig.MaxShadows is zero.
+const DefaultMaxShadows = 16
+
+// CanaryConfig defines how CanaryRouter splits traffic between stable and canary pools.
+type CanaryConfig struct {
+	// Percent of requests (from 0 to 100) translated by the canary pool
+	Percent float64
+
+	// Shadow enables translating requests served by the stable pool with the canary pool too.
+	// Shadow translations are done in background, their outputs are not returned to callers
+	// but logged along with stable ones for comparison.
+	Shadow bool
+	// MaxShadows limits the number of shadow translations running at the same time, so a slow canary
+	// pool does not pile them up. Requests above it are not shadowed, which is counted in Shadow
+	// ArmStats.Dropped. Zero means DefaultMaxShadows.
+	MaxShadows int
+
+	// Logger receives shadow translations outputs. If nil, they are discarded.
+	Logger *slog.Logger
+}
+
+func (cfg CanaryConfig) Validate() error {
+	if cfg.Percent < 0 || cfg.Percent > 100 {
+		return fmt.Errorf("canary percent %v is out of [0, 100] range", cfg.Percent)
+	}
+	if cfg.MaxShadows < 0 {
+		return fmt.Errorf("max shadows %d must not be negative", cfg.MaxShadows)
+	}
+	return nil
+}
+
+// CanaryRouter splits translation requests between pools with stable and canary
+// (e.g. built from a new model version FilesBundle) translators.
+// CanaryRouter does not own the pools, so they must be closed by the caller after the router.
+type CanaryRouter struct {
+	stable, canary *Pool
+	cfg            CanaryConfig
+	logger         *slog.Logger
+
+	// shadowSlots is a semaphore limiting running shadow translations
+	shadowSlots chan struct{}
+	// mu guards closed, so shadows are not started after Close has begun to wait for them
+	mu      sync.RWMutex
+	closed  bool
+	shadows sync.WaitGroup
+	// shadowsCtx is canceled by Close to abort shadow translations if Close is canceled itself
+	shadowsCtx   context.Context
+	abortShadows context.CancelFunc
+
+	stableStats, canaryStats, shadowStats armCounters
+}
+
+// errRouterClosed is returned by CanaryRouter calls after Close.
+var errRouterClosed = fmt.Errorf("canary router %w", ErrClosed)
+
+// CanaryStats contains statistics of CanaryRouter arms.
+type CanaryStats struct {
+	// Stable describes requests served by the stable pool
+	Stable ArmStats
+	// Canary describes requests served by the canary pool
+	Canary ArmStats
+	// Shadow describes background canary translations of requests served by the stable pool
+	Shadow ArmStats
+}
+
+// ArmStats contains statistics of a single CanaryRouter arm.
+type ArmStats struct {
+	// Requests is a number of TranslateMultiple calls sent to the arm
+	Requests uint64
+	// Errors is a number of failed calls
+	Errors uint64
+	// Mismatches is a number of shadowed texts which canary outputs differ from stable ones.
+	// It is set only for Shadow arm.
+	Mismatches uint64
+	// Dropped is a number of calls not shadowed since CanaryConfig.MaxShadows were running.
+	// It is set only for Shadow arm.
+	Dropped uint64
+	// Latency describes time spent by the arm pool translating requests
+	Latency LatencyStats
+}
+
+type armCounters struct {
+	requests   atomic.Uint64
+	errors     atomic.Uint64
+	mismatches atomic.Uint64
+	dropped    atomic.Uint64
+	latency    latencyWindow
+}
+
+func (c *armCounters) stats() ArmStats {
+	return ArmStats{
+		Requests:   c.requests.Load(),
+		Errors:     c.errors.Load(),
+		Mismatches: c.mismatches.Load(),
+		Dropped:    c.dropped.Load(),
+		Latency:    c.latency.stats(),
+	}
+}
+
+// NewCanaryRouter creates CanaryRouter over given stable and canary pools.
+func NewCanaryRouter(stable, canary *Pool, cfg CanaryConfig) (*CanaryRouter, error) {
+	if stable == nil || canary == nil {
+		return nil, errors.New("both stable and canary pools are required")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.MaxShadows == 0 {
+		cfg.MaxShadows = DefaultMaxShadows
+	}
+	shadowsCtx, abortShadows := context.WithCancel(context.Background())
+	return &CanaryRouter{
+		stable:       stable,
+		canary:       canary,
+		cfg:          cfg,
+		logger:       loggerOrDiscard(cfg.Logger),
+		shadowSlots:  make(chan struct{}, cfg.MaxShadows),
+		shadowsCtx:   shadowsCtx,
+		abortShadows: abortShadows,
+	}, nil
+}
+
+// Translate is similar to Pool.Translate except the request is given to either stable or canary pool.
+func (r *CanaryRouter) Translate(ctx context.Context, request TranslationRequest) (string, error) {
+	output, err := r.TranslateMultiple(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if len(output) < 1 {
+		return "", fmt.Errorf("expected translated texts to have at least 1 element")
+	}
+	return output[0], nil
+}
+
+// TranslateMultiple is similar to Pool.TranslateMultiple except the requests are given
+// to the canary pool with CanaryConfig.Percent probability and to the stable pool otherwise.
+// It fails with ErrClosed after Close.
+func (r *CanaryRouter) TranslateMultiple(ctx context.Context, requests ...TranslationRequest) ([]string, error) {
+	r.mu.RLock()
+	closed := r.closed
+	r.mu.RUnlock()
+	if closed {
+		return nil, errRouterClosed
+	}
+	if rand.Float64()*100 < r.cfg.Percent {
+		return translateWithArm(ctx, r.canary, &r.canaryStats, requests)
+	}
+
+	outputs, err := translateWithArm(ctx, r.stable, &r.stableStats, requests)
+	if err == nil && r.cfg.Shadow {
+		r.startShadow(ctx, requests, outputs)
+	}
+	return outputs, err
+}
+
+// startShadow translates requests with the canary pool in background if a shadow slot is free,
+// the shadow is dropped otherwise.
+func (r *CanaryRouter) startShadow(ctx context.Context, requests []TranslationRequest, stableOutputs []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.shadowSlots <- struct{}{}:
+	default:
+		r.shadowStats.dropped.Add(1)
+		return
+	}
+
+	r.shadows.Add(1)
+	// the shadow outlives the caller request, but keeps its values like the tenant
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(r.shadowsCtx, cancel)
+	requests = slices.Clone(requests)
+	go func() {
+		defer r.shadows.Done()
+		defer func() { <-r.shadowSlots }()
+		defer stop()
+		defer cancel()
+		r.shadow(ctx, requests, stableOutputs)
+	}()
+}
+
+// Close stops shadowing requests and waits for running shadow translations.
+// If ctx is done before, they are aborted. Following calls fail with ErrClosed.
+// Pools are not closed, since the router does not own them.
+func (r *CanaryRouter) Close(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	waited := make(chan struct{})
+	go func() {
+		r.shadows.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		r.abortShadows()
+		return nil
+	case <-ctx.Done():
+		r.abortShadows()
+		<-waited
+		return ctx.Err()
+	}
+}
+
+// Stats returns statistics of the router arms.
+func (r *CanaryRouter) Stats() CanaryStats {
+	return CanaryStats{
+		Stable: r.stableStats.stats(),
+		Canary: r.canaryStats.stats(),
+		Shadow: r.shadowStats.stats(),
+	}
+}
+
+func (r *CanaryRouter) shadow(ctx context.Context, requests []TranslationRequest, stableOutputs []string) {
+	canaryOutputs, err := translateWithArm(ctx, r.canary, &r.shadowStats, requests)
+	if err != nil {
+		r.logger.LogAttrs(ctx, slog.LevelWarn, "shadow translation failed", slog.Any("error", err))
+		return
+	}
+
+	for i := range stableOutputs {
+		var canaryOutput string
+		if i < len(canaryOutputs) {
+			canaryOutput = canaryOutputs[i]
+		}
+		match := canaryOutput == stableOutputs[i]
+		if !match {
+			r.shadowStats.mismatches.Add(1)
+		}
+		r.logger.LogAttrs(ctx, slog.LevelInfo, "shadow translation",
+			slog.String("text", requests[i].Text),
+			slog.String("stable", stableOutputs[i]),
+			slog.String("canary", canaryOutput),
+			slog.Bool("match", match),
+		)
+	}
+}
+
+func translateWithArm(ctx context.Context, pool *Pool, counters *armCounters, requests []TranslationRequest) ([]string, error) {
+	start := time.Now()
+	outputs, err := pool.TranslateMultiple(ctx, requests...)
+	counters.latency.observe(time.Since(start))
+	counters.requests.Add(1)
+	if err != nil {
+		counters.errors.Add(1)
+	}
+	return outputs, err
+}
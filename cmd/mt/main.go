@@ -22,6 +22,10 @@ func main() {
 	vocabPathShort := flag.String("v", "", "源语言词汇表文件路径简写 (必需)")
 	vocab2Path := flag.String("vocab2", "", "目标语言词汇表文件路径")
 	vocab2PathShort := flag.String("v2", "", "目标语言词汇表文件路径简写")
+	cacheDir := flag.String("cache-dir", "", "WASM 编译缓存目录 (默认为用户缓存目录下的 gobergamot)")
+	var executionMode gobergamot.ExecutionMode
+	flag.TextVar(&executionMode, "exec", gobergamot.ExecutionAuto, "WASM 执行方式: auto, compiler 或 interpreter")
+	wasmProfile := flag.String("wasm-profile", "", "将 WASM 调用栈的 pprof 性能分析结果写入该文件")
 
 	// 解析命令行参数
 	flag.Parse()
@@ -106,6 +110,11 @@ func main() {
 		FilesBundle:     bundle,
 		CacheSize:       1000, // 设置适当的缓存大小
 		BergamotOptions: gobergamot.DefaultBergamotOptions(),
+		CacheDir:        *cacheDir,
+		ExecutionMode:   executionMode,
+	}
+	if *wasmProfile != "" {
+		config.Profiler = gobergamot.NewProfiler(0)
 	}
 
 	// 创建上下文
@@ -128,7 +137,25 @@ func main() {
 		},
 	}
 
+	if config.Profiler != nil {
+		profileFile, err := os.Create(*wasmProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "创建性能分析文件错误: %v\n", err)
+			os.Exit(1)
+		}
+		defer profileFile.Close()
+		if err := config.Profiler.Start(profileFile); err != nil {
+			fmt.Fprintf(os.Stderr, "启动性能分析错误: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	result, err := translator.Translate(ctx, request)
+	if config.Profiler != nil {
+		if err := config.Profiler.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "写入性能分析结果错误: %v\n", err)
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "翻译错误: %v\n", err)
 		os.Exit(1)
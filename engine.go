@@ -0,0 +1,317 @@
+package gobergamot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	embind "github.com/jerbob92/wazero-emscripten-embind"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+var ErrEngineClosed = errors.New("engine closed")
+
+// coreFeatures are WASM features enabled for Bergamot module:
+// sentencepiece uses multithreading - so we need WASM threads feature to use Bergamot
+const coreFeatures = api.CoreFeaturesV2 | experimental.CoreFeaturesThreads
+
+// EngineConfig configures the wazero runtime shared by Translators of an Engine.
+// Fields have the same meaning as the Config ones.
+type EngineConfig struct {
+	// WASM is a Bergamot module binary used instead of EmbeddedWASM.
+	WASM []byte
+
+	// GemmBackend implements int8 matrix multiplication imported by Marian. If nil, FallbackGemm is used.
+	GemmBackend GemmBackend
+
+	// WASMCache keeps compiled Bergamot module. If nil, a cache persisted in CacheDir is created
+	// and closed along with Engine.
+	WASMCache wazero.CompilationCache
+
+	// CacheDir is a directory where compiled Bergamot module is kept between process runs
+	// if WASMCache is nil. If empty, DefaultCacheDir is used.
+	CacheDir string
+
+	// WASMUseContext defines if WASM functions execution must be canceled upon context.Context cancellation.
+	WASMUseContext bool
+
+	// MaxMemoryBytes limits linear memory of each Translator. If zero, the limit encoded in the module is used.
+	MaxMemoryBytes uint64
+
+	// ExecutionMode selects wazero compiler or interpreter.
+	ExecutionMode ExecutionMode
+
+	// Logger receives records of Engine lifecycle events. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	// Profiler samples WASM call stacks of Engine Translators. If nil, profiling is disabled.
+	// If set, CacheDir is not used and compiled code is kept in memory only.
+	Profiler *Profiler
+}
+
+func (cfg EngineConfig) Validate() error {
+	var err error
+	if cfg.MaxMemoryBytes != 0 && (cfg.MaxMemoryBytes < uint64(wasmMemoryPageSize) || cfg.MaxMemoryBytes > maxMemoryBytes) {
+		err = errors.Join(err, ErrInvalidMaxMemory)
+	}
+	if cfg.ExecutionMode > ExecutionInterpreter {
+		err = errors.Join(err, ErrInvalidExecutionMode)
+	}
+	return err
+}
+
+// engineConfig returns configuration of the Engine created by New.
+func (cfg Config) engineConfig() EngineConfig {
+	return EngineConfig{
+		WASM:           cfg.WASM,
+		GemmBackend:    cfg.GemmBackend,
+		WASMCache:      cfg.WASMCache,
+		CacheDir:       cfg.CacheDir,
+		WASMUseContext: cfg.WASMUseContext,
+		MaxMemoryBytes: cfg.MaxMemoryBytes,
+		ExecutionMode:  cfg.ExecutionMode,
+		Logger:         cfg.Logger,
+		Profiler:       cfg.Profiler,
+	}
+}
+
+// Engine owns a wazero runtime with compiled Bergamot module and its host modules.
+// Translators created by Engine are separate module instances sharing compiled code
+// and host modules, so they are created faster and take less memory than ones created by New.
+// Engine is safe for concurrent use.
+type Engine struct {
+	cfg    EngineConfig
+	logger *slog.Logger
+	// executionMode is the mode in use, which is resolved if EngineConfig has ExecutionAuto
+	executionMode ExecutionMode
+
+	wasmRuntime    wazero.Runtime
+	compiledModule wazero.CompiledModule
+	// globals are names of mutable global exports, which memory snapshots save along with the memory
+	globals []string
+	// memoryLimit is the size in bytes module memory can not grow over
+	memoryLimit uint64
+
+	// cache is set if compilation cache was created by Engine, so it is closed along with it
+	cache wazero.CompilationCache
+
+	closed atomic.Bool
+}
+
+// NewEngine creates wazero runtime, compiles Bergamot module and instantiates its host modules.
+func NewEngine(ctx context.Context, cfg EngineConfig) (*Engine, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	wasmRuntimeConfig, executionMode, err := cfg.ExecutionMode.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	var module preparedModule
+	if cfg.WASM != nil {
+		module, err = prepareModule(cfg.WASM)
+	} else {
+		module, err = embeddedModule()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{executionMode: executionMode, logger: loggerOrDiscard(cfg.Logger)}
+	if cfg.WASMCache == nil {
+		if cfg.Profiler != nil {
+			// code compiled with listeners is kept in memory only, so persisted entries are not mixed
+			// with instrumented code, which has other module context layout
+			e.cache = wazero.NewCompilationCache()
+		} else {
+			e.cache = newCompilationCache(cfg.CacheDir, cfg.WASM)
+		}
+		cfg.WASMCache = e.cache
+	}
+	if cfg.WASM == nil {
+		cfg.WASM = wasm.BergamotWASM()
+	}
+	e.cfg = cfg
+
+	if cfg.Profiler != nil {
+		// listeners are compiled into the module and host functions, so they are set by the context of compilation
+		sources, err := wasm.FunctionSources(cfg.WASM)
+		if err != nil {
+			e.logger.Warn("failed to read WASM debug info, profile has no C++ source locations", slog.Any("error", err))
+		}
+		ctx = experimental.WithFunctionListenerFactory(ctx, cfg.Profiler.listenerFactory(sources))
+	}
+
+	wasmRuntimeConfig = wasmRuntimeConfig.
+		WithCoreFeatures(coreFeatures).
+		WithCloseOnContextDone(cfg.WASMUseContext).
+		// C++ source locations are added to WASMTrapError stack traces if the module has DWARF sections
+		WithDebugInfoEnabled(wasm.DebugInfo).
+		WithCompilationCache(cfg.WASMCache)
+	e.wasmRuntime = wazero.NewRuntimeWithConfig(ctx, wasmRuntimeConfig)
+
+	e.globals = module.globals
+	limitPages := memoryLimitPages(maxMemoryBytes)
+	if module.hasMax {
+		limitPages = module.maxPages
+	}
+	if cfg.MaxMemoryBytes != 0 {
+		limitPages = min(limitPages, memoryLimitPages(cfg.MaxMemoryBytes))
+	}
+	e.memoryLimit = uint64(limitPages) * uint64(wasmMemoryPageSize)
+	e.compiledModule, err = e.wasmRuntime.CompileModule(ctx, module.binary)
+	if err != nil {
+		_ = e.Close(ctx)
+		return nil, fmt.Errorf("CompileModule: %w", err)
+	}
+	for _, memory := range e.compiledModule.ExportedMemories() {
+		if initial := uint64(memory.Min()) * uint64(wasmMemoryPageSize); initial > e.memoryLimit {
+			_ = e.Close(ctx)
+			return nil, fmt.Errorf("%w: module requires %d bytes initially, the limit is %d bytes",
+				ErrOutOfMemory, initial, e.memoryLimit)
+		}
+	}
+	if err := wasm.CheckExports(e.compiledModule, wasm.CompileConfig{GemmBackend: cfg.GemmBackend}); err != nil {
+		_ = e.Close(ctx)
+		return nil, err
+	}
+	// embind host functions take engine of the calling module instance from context,
+	// so a temporary engine is enough to build them
+	err = wasm.BuildImports(ctx, e.wasmRuntime, embind.CreateEngine(embind.NewConfig()), e.compiledModule,
+		wasm.CompileConfig{GemmBackend: cfg.GemmBackend})
+	if err != nil {
+		_ = e.Close(ctx)
+		return nil, fmt.Errorf("BuildImports: %w", err)
+	}
+	if err := wasm.CheckImports(e.wasmRuntime, e.compiledModule); err != nil {
+		_ = e.Close(ctx)
+		return nil, err
+	}
+	e.logger.Info("engine created",
+		slog.String("execution_mode", executionMode.String()),
+		slog.Duration("duration", time.Since(start)),
+	)
+	return e, nil
+}
+
+// preparedModule is a Bergamot module binary rewritten to be run by Engine.
+type preparedModule struct {
+	binary []byte
+	// globals are names of mutable global exports, which memory snapshots save along with the memory
+	globals []string
+	// maxPages is the memory maximum removed from the module, hasMax is unset if it had none
+	maxPages uint32
+	hasMax   bool
+}
+
+// embeddedModule is EmbeddedWASM prepared once for all Engines. Modules set with EngineConfig.WASM
+// are prepared by every Engine using them, since the library does not keep them.
+var embeddedModule = sync.OnceValues(func() (preparedModule, error) {
+	return prepareModule(wasm.BergamotWASM())
+})
+
+// prepareModule exports mutable globals of the module for memory snapshots and removes its memory
+// maximum, so the memory limit is enforced by memory allocators, see memoryAllocator.
+func prepareModule(module []byte) (preparedModule, error) {
+	// Emscripten keeps most of mutable globals internal, so they are exported for memory snapshots
+	binary, globals, err := wasm.ExportMutableGlobals(module)
+	if err != nil {
+		return preparedModule{}, fmt.Errorf("failed to export mutable globals: %w", err)
+	}
+	binary, maxPages, hasMax, err := wasm.RemoveMemoryMax(binary)
+	if err != nil {
+		return preparedModule{}, fmt.Errorf("failed to remove memory limit: %w", err)
+	}
+	return preparedModule{binary: binary, globals: globals, maxPages: maxPages, hasMax: hasMax}, nil
+}
+
+// ExecutionMode returns the mode used to run modules, which is either ExecutionCompiler or ExecutionInterpreter.
+func (e *Engine) ExecutionMode() ExecutionMode {
+	return e.executionMode
+}
+
+// NewTranslator creates a Translator as a new module instance in the Engine runtime.
+// Runtime related fields of cfg, which are GemmBackend, WASMCache, CacheDir, WASMUseContext,
+// MaxMemoryBytes and ExecutionMode, are ignored in favor of EngineConfig ones.
+// The Translator must be closed before Engine, otherwise Engine closes its module.
+func (e *Engine) NewTranslator(ctx context.Context, cfg Config) (*Translator, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BergamotOptions == nil {
+		cfg.BergamotOptions = DefaultBergamotOptions()
+	}
+	if e.closed.Load() {
+		return nil, ErrEngineClosed
+	}
+
+	start := time.Now()
+	tr := &Translator{engine: e, cfg: cfg, logger: loggerOrDiscard(cfg.Logger)}
+	if cfg.Logger != nil {
+		// Bergamot logs are sent to the logger unless outputs are set explicitly
+		if tr.cfg.Stderr == nil {
+			tr.cfg.Stderr = NewMarianLogWriter(cfg.Logger, slog.LevelInfo)
+		}
+		if tr.cfg.Stdout == nil {
+			tr.cfg.Stdout = NewMarianLogWriter(cfg.Logger, slog.LevelInfo)
+		}
+	}
+	if e.cfg.WASMUseContext {
+		// module is closed upon context cancellation, so data is kept to load it into a new instance
+		files, err := readBundle(cfg.FilesBundle)
+		if err != nil {
+			return nil, err
+		}
+		tr.files = &files
+		cfg.FilesBundle = files.filesBundle()
+	}
+
+	if err := tr.instantiate(ctx, cfg.FilesBundle); err != nil {
+		err = outOfMemoryError(tr.memory, asTrapError("new", err))
+		// partially initialized module is released
+		if tr.module != nil {
+			_ = tr.module.Close(context.WithoutCancel(ctx))
+		}
+		tr.logger.Error("failed to create translator", slog.Any("error", err))
+		return nil, err
+	}
+	tr.logger.Info("translator created",
+		slog.Duration("duration", time.Since(start)),
+		slog.Uint64("memory_bytes", tr.memorySize()),
+	)
+	return tr, nil
+}
+
+// instantiate creates a new Bergamot module instance. ctx must have embindEngine attached.
+func (e *Engine) instantiate(ctx context.Context, embindEngine embind.Engine, cfg wasm.CompileConfig) (api.Module, error) {
+	if e.closed.Load() {
+		return nil, ErrEngineClosed
+	}
+	return wasm.InstantiateBergamot(ctx, e.wasmRuntime, embindEngine, e.compiledModule, cfg)
+}
+
+// Close closes the runtime with all Translator module instances created by the Engine.
+// Translators left open fail their calls with ErrEngineClosed.
+func (e *Engine) Close(ctx context.Context) error {
+	if !e.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	err := e.wasmRuntime.Close(ctx)
+	if e.cache != nil {
+		_ = e.cache.Close(context.WithoutCancel(ctx))
+	}
+	e.logger.Debug("engine closed")
+	return err
+}
@@ -0,0 +1,97 @@
+package gobergamot
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/experimental"
+
+	"github.com/xxnuo/gobergamot/internal/platform"
+)
+
+// ExecutionMode selects how wazero runs the Bergamot module.
+type ExecutionMode uint8
+
+const (
+	// ExecutionAuto uses the compiler if the platform supports it and the interpreter otherwise.
+	ExecutionAuto ExecutionMode = iota
+	// ExecutionCompiler compiles the module into native code. Engine creation fails
+	// with ErrCompilerUnsupported if the platform does not support it.
+	ExecutionCompiler
+	// ExecutionInterpreter interprets the module. It is much slower than the compiler, but works on any platform.
+	ExecutionInterpreter
+)
+
+var (
+	ErrCompilerUnsupported  = errors.New("wazero compiler is not supported on this platform")
+	ErrInvalidExecutionMode = errors.New("invalid execution mode")
+)
+
+var executionModeNames = [...]string{
+	ExecutionAuto:        "auto",
+	ExecutionCompiler:    "compiler",
+	ExecutionInterpreter: "interpreter",
+}
+
+func (m ExecutionMode) String() string {
+	if int(m) < len(executionModeNames) {
+		return executionModeNames[m]
+	}
+	return fmt.Sprintf("ExecutionMode(%d)", m)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m ExecutionMode) MarshalText() ([]byte, error) {
+	if int(m) >= len(executionModeNames) {
+		return nil, ErrInvalidExecutionMode
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, so ExecutionMode can be set by its name
+// in configuration files or with flag.TextVar.
+func (m *ExecutionMode) UnmarshalText(text []byte) error {
+	for mode, name := range executionModeNames {
+		if strings.EqualFold(string(text), name) {
+			*m = ExecutionMode(mode)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrInvalidExecutionMode, text)
+}
+
+// resolve returns wazero runtime config for the mode and the mode used actually.
+func (m ExecutionMode) resolve() (wazero.RuntimeConfig, ExecutionMode, error) {
+	switch m {
+	case ExecutionAuto:
+		if CompilerSupported() {
+			return wazero.NewRuntimeConfigCompiler(), ExecutionCompiler, nil
+		}
+		return wazero.NewRuntimeConfigInterpreter(), ExecutionInterpreter, nil
+	case ExecutionCompiler:
+		// wazero panics upon compilation if the compiler is not supported
+		if !CompilerSupported() {
+			return nil, m, fmt.Errorf("%w: %s/%s", ErrCompilerUnsupported, runtime.GOOS, runtime.GOARCH)
+		}
+		return wazero.NewRuntimeConfigCompiler(), m, nil
+	case ExecutionInterpreter:
+		return wazero.NewRuntimeConfigInterpreter(), m, nil
+	default:
+		return nil, m, fmt.Errorf("%w %d", ErrInvalidExecutionMode, m)
+	}
+}
+
+// CompilerSupported reports if wazero compiler supports the platform and CPU features Bergamot module requires.
+func CompilerSupported() bool {
+	return compilerSupported()
+}
+
+// compilerSupported repeats the check wazero makes to pick the compiler, since it does not export it.
+var compilerSupported = sync.OnceValue(func() bool {
+	threads := coreFeatures.IsEnabled(experimental.CoreFeaturesThreads)
+	return platform.CompilerSupports(runtime.GOOS, runtime.GOARCH, platform.HostCPU, threads)
+})
@@ -87,3 +87,57 @@ func (f *alignedMemoryFile) readAll() ([]byte, error) {
 
 	return data, nil
 }
+
+// bundleBytes keeps FilesBundle contents in memory,
+// so the same data can be loaded into several WASM module instances.
+type bundleBytes struct {
+	model        []byte
+	shortlist    []byte
+	vocabularies [][]byte
+}
+
+func readBundle(bundle FilesBundle) (bundleBytes, error) {
+	var (
+		files bundleBytes
+		err   error
+	)
+	wrappingFile := new(alignedMemoryFile)
+
+	wrappingFile.Reader = bundle.Model
+	files.model, err = wrappingFile.readAll()
+	if err != nil {
+		return bundleBytes{}, fmt.Errorf("failed to read model: %w", err)
+	}
+
+	wrappingFile.Reader = bundle.LexicalShortlist
+	files.shortlist, err = wrappingFile.readAll()
+	if err != nil {
+		return bundleBytes{}, fmt.Errorf("failed to read shortlist: %w", err)
+	}
+
+	// Read all vocabularies
+	files.vocabularies = make([][]byte, len(bundle.Vocabularies))
+	for i, vocab := range bundle.Vocabularies {
+		wrappingFile.Reader = vocab
+		vocabBytes, err := wrappingFile.readAll()
+		if err != nil {
+			return bundleBytes{}, fmt.Errorf("failed to read vocabulary %d: %w", i, err)
+		}
+		files.vocabularies[i] = vocabBytes
+	}
+
+	return files, nil
+}
+
+// filesBundle creates FilesBundle with new readers over kept contents.
+func (b bundleBytes) filesBundle() FilesBundle {
+	vocabularies := make([]io.Reader, len(b.vocabularies))
+	for i, vocabBytes := range b.vocabularies {
+		vocabularies[i] = bytes.NewBuffer(vocabBytes)
+	}
+	return FilesBundle{
+		Model:            bytes.NewBuffer(b.model),
+		LexicalShortlist: bytes.NewBuffer(b.shortlist),
+		Vocabularies:     vocabularies,
+	}
+}
@@ -0,0 +1,26 @@
+package gobergamot
+
+import (
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+// GemmBackend implements int8 matrix multiplication functions Marian imports from wasm_gemm module.
+// It is set with Config.GemmBackend, FallbackGemm is used by default.
+type GemmBackend = wasm.GemmBackend
+
+// FallbackGemm returns GemmBackend calling intgemm compiled into Bergamot WASM module.
+func FallbackGemm() GemmBackend {
+	return wasm.FallbackGemm()
+}
+
+// NativeGemm returns GemmBackend implemented in Go with AVX2 on amd64 and NEON on arm64,
+// which is much faster than FallbackGemm. It requires a little endian host.
+func NativeGemm() GemmBackend {
+	return wasm.NativeGemm()
+}
+
+// ReferenceGemm returns GemmBackend implemented in Go with plain loops.
+// It is slow and is meant to check other backends.
+func ReferenceGemm() GemmBackend {
+	return wasm.ReferenceGemm()
+}
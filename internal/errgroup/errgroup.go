@@ -9,12 +9,15 @@ import (
 // but this Errgroup does wait for all goroutines to complete
 // and returns joined error.
 type Errgroup struct {
-	wg    sync.WaitGroup
-	errCh chan error
+	wg sync.WaitGroup
+	// errs are collected under mu rather than sent to Wait,
+	// so goroutines completing long before Wait is called do not block
+	mu   sync.Mutex
+	errs []error
 }
 
 func New() Errgroup {
-	return Errgroup{errCh: make(chan error)}
+	return Errgroup{}
 }
 
 // Go calls given function in a new goroutine.
@@ -23,22 +26,20 @@ func (eg *Errgroup) Go(f func() error) {
 	eg.wg.Add(1)
 	go func() {
 		defer eg.wg.Done()
-		eg.errCh <- f()
+		if err := f(); err != nil {
+			eg.mu.Lock()
+			eg.errs = append(eg.errs, err)
+			eg.mu.Unlock()
+		}
 	}()
 }
 
 // Wait waits for completion of all launched goroutines
 // and returns composite error formed with errors.Join
 func (eg *Errgroup) Wait() error {
-	go func() {
-		defer close(eg.errCh)
-		eg.wg.Wait()
-	}()
-
-	var joinedErr error
+	eg.wg.Wait()
 
-	for err := range eg.errCh {
-		joinedErr = errors.Join(joinedErr, err)
-	}
-	return joinedErr
+	eg.mu.Lock()
+	defer eg.mu.Unlock()
+	return errors.Join(eg.errs...)
 }
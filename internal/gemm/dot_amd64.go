@@ -0,0 +1,47 @@
+package gemm
+
+import (
+	"unsafe"
+)
+
+// dotBlock is the number of bytes processed by one iteration of AVX2 kernel
+const dotBlock = 32
+
+var hasAVX2 = detectAVX2()
+
+func dotBlocks(a []uint8, b []int8) int32 {
+	if !hasAVX2 {
+		return dotGeneric(a, b)
+	}
+	return dotAVX2(unsafe.SliceData(a), unsafe.SliceData(b), len(a))
+}
+
+func detectAVX2() bool {
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return false
+	}
+	const (
+		osxsaveBit = 1 << 27
+		avxBit     = 1 << 28
+		avx2Bit    = 1 << 5
+		// XMM and YMM states must be enabled by OS
+		ymmState = 0b110
+	)
+	_, _, ecx1, _ := cpuid(1, 0)
+	if ecx1&osxsaveBit == 0 || ecx1&avxBit == 0 {
+		return false
+	}
+	if xcr0, _ := xgetbv(); xcr0&ymmState != ymmState {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	return ebx7&avx2Bit != 0
+}
+
+//go:noescape
+func dotAVX2(a *uint8, b *int8, n int) int32
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
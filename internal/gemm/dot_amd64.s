@@ -0,0 +1,59 @@
+#include "textflag.h"
+
+// func dotAVX2(a *uint8, b *int8, n int) int32
+// n must be a multiple of 32.
+TEXT ·dotAVX2(SB), NOSPLIT, $0-28
+	MOVQ a+0(FP), SI
+	MOVQ b+8(FP), DI
+	MOVQ n+16(FP), CX
+
+	VPXOR Y0, Y0, Y0
+	// 16-bit ones to sum pairs of int16 into int32
+	MOVL $0x00010001, AX
+	VMOVD AX, X1
+	VPBROADCASTD X1, Y1
+
+loop:
+	CMPQ CX, $0
+	JE   done
+	VMOVDQU (SI), Y2
+	VMOVDQU (DI), Y3
+	// unsigned a * signed b, adjacent pairs summed with int16 saturation
+	VPMADDUBSW Y3, Y2, Y4
+	VPMADDWD   Y1, Y4, Y4
+	VPADDD     Y4, Y0, Y0
+	ADDQ $32, SI
+	ADDQ $32, DI
+	SUBQ $32, CX
+	JMP  loop
+
+done:
+	VEXTRACTI128 $1, Y0, X1
+	VPADDD       X1, X0, X0
+	VPSHUFD      $0x4e, X0, X1
+	VPADDD       X1, X0, X0
+	VPSHUFD      $0xb1, X0, X1
+	VPADDD       X1, X0, X0
+	VMOVD        X0, AX
+	MOVL         AX, ret+24(FP)
+	VZEROUPPER
+	RET
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
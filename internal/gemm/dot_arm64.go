@@ -0,0 +1,15 @@
+package gemm
+
+import (
+	"unsafe"
+)
+
+// dotBlock is the number of bytes processed by one iteration of NEON kernel
+const dotBlock = 16
+
+func dotBlocks(a []uint8, b []int8) int32 {
+	return dotNEON(unsafe.SliceData(a), unsafe.SliceData(b), len(a))
+}
+
+//go:noescape
+func dotNEON(a *uint8, b *int8, n int) int32
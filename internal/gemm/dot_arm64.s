@@ -0,0 +1,40 @@
+#include "textflag.h"
+
+// func dotNEON(a *uint8, b *int8, n int) int32
+// n must be a multiple of 16.
+TEXT ·dotNEON(SB), NOSPLIT, $0-28
+	MOVD a+0(FP), R0
+	MOVD b+8(FP), R1
+	MOVD n+16(FP), R2
+
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+
+loop:
+	CBZ R2, done
+	VLD1.P 16(R0), [V2.B16]
+	VLD1.P 16(R1), [V3.B16]
+	// widening to int16, products fit as |a*b| <= 255*128
+	VUXTL  V2.B8, V4.H8
+	VUXTL2 V2.B16, V5.H8
+	VSXTL  V3.B8, V6.H8
+	VSXTL2 V3.B16, V7.H8
+	VMUL   V6.H8, V4.H8, V4.H8
+	VMUL   V7.H8, V5.H8, V5.H8
+	// adjacent pairs summed with int16 saturation like x86 maddubs does
+	VUZP1  V5.H8, V4.H8, V6.H8
+	VUZP2  V5.H8, V4.H8, V7.H8
+	VSQADD V7.H8, V6.H8, V6.H8
+	VSXTL  V6.H4, V7.S4
+	VSXTL2 V6.H8, V6.S4
+	VADD   V7.S4, V0.S4, V0.S4
+	VADD   V6.S4, V1.S4, V1.S4
+	SUB    $16, R2
+	B      loop
+
+done:
+	VADD  V1.S4, V0.S4, V0.S4
+	VADDV V0.S4, V0
+	VMOV  V0.S[0], R3
+	MOVW  R3, ret+24(FP)
+	RET
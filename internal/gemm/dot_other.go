@@ -0,0 +1,10 @@
+//go:build !amd64 && !arm64
+
+package gemm
+
+// dotBlock is the number of bytes processed at once, there is no SIMD kernel for the architecture
+const dotBlock = 2
+
+func dotBlocks(a []uint8, b []int8) int32 {
+	return dotGeneric(a, b)
+}
@@ -0,0 +1,176 @@
+// Package gemm implements wasm_gemm module functions imported by Marian natively in Go.
+//
+// Native is an optimised implementation using SIMD kernels, Reference is a straightforward one
+// used to check others. Semantics follow intgemm Int8Shift as used by Marian fallback functions
+// (https://github.com/browsermt/marian-dev/blob/master/src/tensors/cpu/wasm_intgemm_fallback.cpp):
+// A is quantized to int8 and shifted by 127 to be unsigned, B is quantized to int8, and products of
+// adjacent pairs along the width are summed with int16 saturation like SSSE3/AVX2 maddubs instructions do.
+//
+// Prepared B layout is opaque to Marian, which only passes prepared B back to functions of the same
+// implementation, so implementations use layouts convenient for them rather than intgemm ones.
+package gemm
+
+import (
+	"math"
+)
+
+const (
+	maxQuantized = 127
+	minQuantized = -127
+	// shift makes quantized A values unsigned
+	shift = 127
+)
+
+// Implementation defines wasm_gemm functions operating on slices of the module memory.
+// Matrices are row-major unless specified otherwise.
+type Implementation interface {
+	// PrepareA quantizes rows x width A and shifts it to be unsigned.
+	PrepareA(input []float32, scale float32, rows, width int, output []uint8)
+	// PrepareB quantizes width x cols B into prepared layout.
+	PrepareB(input []float32, scale float32, width, cols int, output []int8)
+	// PrepareBFromTransposed quantizes cols x width transposed B into prepared layout.
+	PrepareBFromTransposed(input []float32, scale float32, width, cols int, output []int8)
+	// PrepareBFromQuantizedTransposed converts already quantized cols x width transposed B into prepared layout.
+	PrepareBFromQuantizedTransposed(input []int8, width, cols int, output []int8)
+	// PrepareBias compensates bias for the shift of A: the shift adds 127 * sum of B column to every
+	// multiplication result, so it is subtracted from bias beforehand.
+	PrepareBias(inputB []int8, scaleA, scaleB float32, width, cols int, bias, output []float32)
+	// MultiplyAndAddBias multiplies prepared rows x width A by prepared width x cols B, unquantizes
+	// results and adds prepared bias. Output is rows x cols.
+	MultiplyAndAddBias(
+		inputA []uint8, scaleA float32,
+		inputB []int8, scaleB float32,
+		bias []float32, unquantMultiplier float32,
+		rows, width, cols int,
+		output []float32,
+	)
+	// SelectColumnsOfB copies selected columns of prepared B into output, which is prepared B
+	// with len(selected) columns.
+	SelectColumnsOfB(input []int8, width, cols int, selected []uint32, output []int8)
+}
+
+// Native implements wasm_gemm functions with AVX2 on amd64 and NEON on arm64.
+// Prepared B is stored column-major, i.e. as quantized transposed B.
+type Native struct{}
+
+var _ Implementation = Native{}
+
+func (Native) PrepareA(input []float32, scale float32, rows, width int, output []uint8) {
+	n := rows * width
+	input, output = input[:n], output[:n]
+	for i := range input {
+		output[i] = uint8(quantize(input[i], scale) + shift)
+	}
+}
+
+func (Native) PrepareB(input []float32, scale float32, width, cols int, output []int8) {
+	input, output = input[:width*cols], output[:width*cols]
+	for k := 0; k < width; k++ {
+		row := input[k*cols : (k+1)*cols]
+		for c := range row {
+			output[c*width+k] = int8(quantize(row[c], scale))
+		}
+	}
+}
+
+func (Native) PrepareBFromTransposed(input []float32, scale float32, width, cols int, output []int8) {
+	input, output = input[:width*cols], output[:width*cols]
+	for i := range input {
+		output[i] = int8(quantize(input[i], scale))
+	}
+}
+
+func (Native) PrepareBFromQuantizedTransposed(input []int8, width, cols int, output []int8) {
+	copy(output[:width*cols], input[:width*cols])
+}
+
+func (Native) SelectColumnsOfB(input []int8, width, cols int, selected []uint32, output []int8) {
+	input, output = input[:width*cols], output[:width*len(selected)]
+	for i, col := range selected {
+		copy(output[i*width:(i+1)*width], input[int(col)*width:(int(col)+1)*width])
+	}
+}
+
+func (Native) PrepareBias(inputB []int8, scaleA, scaleB float32, width, cols int, bias, output []float32) {
+	// the same expression as in Marian fallback to get the same float rounding
+	unquantFactor := float32(-1) * ((float32(127) / scaleA) * (float32(127) / scaleB)) / float32(127)
+	inputB, bias, output = inputB[:width*cols], bias[:cols], output[:cols]
+	for c := range output {
+		var sum int32
+		for _, b := range inputB[c*width : (c+1)*width] {
+			sum += int32(b)
+		}
+		output[c] = unquantizeAndAddBias(sum, unquantFactor, bias[c])
+	}
+}
+
+func (Native) MultiplyAndAddBias(
+	inputA []uint8, scaleA float32,
+	inputB []int8, scaleB float32,
+	bias []float32, unquantMultiplier float32,
+	rows, width, cols int,
+	output []float32,
+) {
+	unquantFactor := unquantMultiplier / (scaleA * scaleB)
+	inputA, inputB, bias, output = inputA[:rows*width], inputB[:width*cols], bias[:cols], output[:rows*cols]
+	for r := 0; r < rows; r++ {
+		a := inputA[r*width : (r+1)*width]
+		out := output[r*cols : (r+1)*cols]
+		for c := range out {
+			out[c] = unquantizeAndAddBias(dot(a, inputB[c*width:(c+1)*width]), unquantFactor, bias[c])
+		}
+	}
+}
+
+// quantize rounds to nearest even like cvtps_epi32 does and clips the result to [-127, 127].
+func quantize(v, scale float32) int32 {
+	q := math.RoundToEven(float64(v * scale))
+	switch {
+	case q > maxQuantized:
+		return maxQuantized
+	case q < minQuantized:
+		return minQuantized
+	default:
+		return int32(q)
+	}
+}
+
+func unquantizeAndAddBias(sum int32, unquantFactor, bias float32) float32 {
+	// explicit conversion prevents fusing into FMA, which SIMD intgemm callbacks do not use
+	return float32(float32(sum)*unquantFactor) + bias
+}
+
+// dot returns sum of a[i]*b[i] where products of adjacent pairs are summed with int16 saturation.
+func dot(a []uint8, b []int8) int32 {
+	b = b[:len(a)]
+	n := len(a) - len(a)%dotBlock
+	var sum int32
+	if n > 0 {
+		sum = dotBlocks(a[:n], b[:n])
+	}
+	return sum + dotGeneric(a[n:], b[n:])
+}
+
+func dotGeneric(a []uint8, b []int8) int32 {
+	b = b[:len(a)]
+	var sum int32
+	n := len(a) &^ 1
+	for k := 0; k < n; k += 2 {
+		sum += saturateInt16(int32(a[k])*int32(b[k]) + int32(a[k+1])*int32(b[k+1]))
+	}
+	if n < len(a) {
+		sum += int32(a[n]) * int32(b[n])
+	}
+	return sum
+}
+
+func saturateInt16(v int32) int32 {
+	switch {
+	case v > math.MaxInt16:
+		return math.MaxInt16
+	case v < math.MinInt16:
+		return math.MinInt16
+	default:
+		return v
+	}
+}
@@ -0,0 +1,114 @@
+package gemm_test
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/xxnuo/gobergamot/internal/gemm"
+)
+
+var implementations = []struct {
+	name string
+	impl gemm.Implementation
+}{
+	{name: "native", impl: gemm.Native{}},
+	{name: "reference", impl: gemm.Reference{}},
+}
+
+func TestNative_Saturation(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, width := range []int{1, 2, 15, 16, 31, 32, 33, 64, 256, 257} {
+		const rows, cols = 3, 5
+		a := make([]uint8, rows*width)
+		transposedB := make([]int8, width*cols)
+		for i := range a {
+			// extremes make maddubs pairs saturate
+			a[i] = []uint8{0, 1, 127, 254, 255, uint8(rng.IntN(256))}[rng.IntN(6)]
+		}
+		for i := range transposedB {
+			transposedB[i] = []int8{-128, -127, -1, 0, 1, 127, int8(rng.IntN(256) - 128)}[rng.IntN(7)]
+		}
+
+		var outputs [2][]float32
+		for i, tt := range implementations {
+			preparedB := make([]int8, width*cols)
+			tt.impl.PrepareBFromQuantizedTransposed(transposedB, width, cols, preparedB)
+			outputs[i] = make([]float32, rows*cols)
+			tt.impl.MultiplyAndAddBias(a, 1, preparedB, 1, make([]float32, cols), 1, rows, width, cols, outputs[i])
+		}
+		for i := range outputs[0] {
+			if outputs[0][i] != outputs[1][i] {
+				t.Fatalf("width %d: element %d is %v, expected %v", width, i, outputs[0][i], outputs[1][i])
+			}
+		}
+	}
+}
+
+func TestImplementation_Float(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	const rows, width, cols = 4, 96, 7
+	a := make([]float32, rows*width)
+	b := make([]float32, width*cols)
+	bias := make([]float32, cols)
+	for _, m := range [][]float32{a, b, bias} {
+		for i := range m {
+			m[i] = rng.Float32()*2 - 1
+		}
+	}
+	// B range is halved so that maddubs pairs do not saturate, as it is with real models
+	scaleA, scaleB := float32(127), float32(63)
+
+	// selecting all columns in reverse order must reverse output columns
+	selected := make([]uint32, cols)
+	selectedBias := make([]float32, cols)
+	for i := range selected {
+		selected[i] = uint32(cols - 1 - i)
+		selectedBias[i] = bias[selected[i]]
+	}
+
+	for _, tt := range implementations {
+		t.Run(tt.name, func(t *testing.T) {
+			preparedA := make([]uint8, len(a))
+			tt.impl.PrepareA(a, scaleA, rows, width, preparedA)
+			preparedB := make([]int8, len(b))
+			tt.impl.PrepareB(b, scaleB, width, cols, preparedB)
+			selectedB := make([]int8, len(b))
+			tt.impl.SelectColumnsOfB(preparedB, width, cols, selected, selectedB)
+
+			preparedBias := make([]float32, cols)
+			tt.impl.PrepareBias(selectedB, scaleA, scaleB, width, cols, selectedBias, preparedBias)
+			output := make([]float32, rows*cols)
+			tt.impl.MultiplyAndAddBias(preparedA, scaleA, selectedB, scaleB, preparedBias, 1, rows, width, cols, output)
+
+			for r := 0; r < rows; r++ {
+				for i, col := range selected {
+					expected := bias[col]
+					for k := 0; k < width; k++ {
+						expected += a[r*width+k] * b[k*cols+int(col)]
+					}
+					if got := output[r*cols+i]; math.Abs(float64(got-expected)) > 0.1 {
+						t.Errorf("element %d,%d is %v, expected about %v", r, col, got, expected)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMultiplyAndAddBias(b *testing.B) {
+	// shapes of a transformer-base output projection with a shortlist
+	const rows, width, cols = 32, 512, 256
+	a := make([]uint8, rows*width)
+	prepared := make([]int8, width*cols)
+	bias := make([]float32, cols)
+	output := make([]float32, rows*cols)
+	for _, tt := range implementations {
+		b.Run(tt.name, func(b *testing.B) {
+			b.SetBytes(rows * width * cols)
+			for i := 0; i < b.N; i++ {
+				tt.impl.MultiplyAndAddBias(a, 1, prepared, 1, bias, 1, rows, width, cols, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package gemm
+
+// Reference implements wasm_gemm functions with plain loops following intgemm Int8Shift definitions.
+// It is slow and is meant to check other implementations. Prepared B is stored row-major,
+// i.e. as quantized B, so it shares no layout code with Native.
+type Reference struct{}
+
+var _ Implementation = Reference{}
+
+func (Reference) PrepareA(input []float32, scale float32, rows, width int, output []uint8) {
+	for i := 0; i < rows*width; i++ {
+		output[i] = uint8(quantize(input[i], scale) + shift)
+	}
+}
+
+func (Reference) PrepareB(input []float32, scale float32, width, cols int, output []int8) {
+	for i := 0; i < width*cols; i++ {
+		output[i] = int8(quantize(input[i], scale))
+	}
+}
+
+func (Reference) PrepareBFromTransposed(input []float32, scale float32, width, cols int, output []int8) {
+	for c := 0; c < cols; c++ {
+		for k := 0; k < width; k++ {
+			output[k*cols+c] = int8(quantize(input[c*width+k], scale))
+		}
+	}
+}
+
+func (Reference) PrepareBFromQuantizedTransposed(input []int8, width, cols int, output []int8) {
+	for c := 0; c < cols; c++ {
+		for k := 0; k < width; k++ {
+			output[k*cols+c] = input[c*width+k]
+		}
+	}
+}
+
+func (Reference) PrepareBias(inputB []int8, scaleA, scaleB float32, width, cols int, bias, output []float32) {
+	// the shift of A is compensated by multiplying a row of ones shifted to 127 by B
+	ones := make([]uint8, width)
+	for k := range ones {
+		ones[k] = 1
+	}
+	unquantFactor := float32(-1) * ((float32(127) / scaleA) * (float32(127) / scaleB)) / float32(127)
+	for c := 0; c < cols; c++ {
+		output[c] = unquantizeAndAddBias(referenceDot(ones, inputB, width, cols, c), unquantFactor, bias[c])
+	}
+}
+
+func (Reference) MultiplyAndAddBias(
+	inputA []uint8, scaleA float32,
+	inputB []int8, scaleB float32,
+	bias []float32, unquantMultiplier float32,
+	rows, width, cols int,
+	output []float32,
+) {
+	unquantFactor := unquantMultiplier / (scaleA * scaleB)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			sum := referenceDot(inputA[r*width:(r+1)*width], inputB, width, cols, c)
+			output[r*cols+c] = unquantizeAndAddBias(sum, unquantFactor, bias[c])
+		}
+	}
+}
+
+func (Reference) SelectColumnsOfB(input []int8, width, cols int, selected []uint32, output []int8) {
+	for k := 0; k < width; k++ {
+		for i, col := range selected {
+			output[k*len(selected)+i] = input[k*cols+int(col)]
+		}
+	}
+}
+
+// referenceDot multiplies row a by column c of row-major width x cols b like maddubs does.
+func referenceDot(a []uint8, b []int8, width, cols, c int) int32 {
+	var sum int32
+	for k := 0; k < width; k += 2 {
+		pair := int32(a[k]) * int32(b[k*cols+c])
+		if k+1 < width {
+			pair += int32(a[k+1]) * int32(b[(k+1)*cols+c])
+		}
+		sum += saturateInt16(pair)
+	}
+	return sum
+}
@@ -0,0 +1,13 @@
+package platform
+
+func hostCPU() CPU {
+	const sse41Bit = 1 << 19
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 1 {
+		return CPU{}
+	}
+	_, _, ecx, _ := cpuid(1, 0)
+	return CPU{SSE41: ecx&sse41Bit != 0}
+}
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
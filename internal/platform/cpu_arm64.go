@@ -0,0 +1,19 @@
+package platform
+
+import "runtime"
+
+func hostCPU() CPU {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		// userland can not read instruction set attributes, but these OSes require atomic instructions
+		return CPU{Atomics: true}
+	case "linux", "freebsd":
+		// the atomic field of ID_AA64ISAR0_EL1 is 2 or greater if LSE atomic instructions are supported
+		const atomicBit = 1 << 21
+		return CPU{Atomics: getisar0()&atomicBit != 0}
+	default:
+		return CPU{}
+	}
+}
+
+func getisar0() uint64
@@ -0,0 +1,8 @@
+#include "textflag.h"
+
+// func getisar0() uint64
+TEXT ·getisar0(SB), NOSPLIT, $0-8
+	// mrs x0, ID_AA64ISAR0_EL1
+	WORD $0xd5380600
+	MOVD R0, ret+0(FP)
+	RET
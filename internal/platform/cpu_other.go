@@ -0,0 +1,7 @@
+//go:build !amd64 && !arm64
+
+package platform
+
+func hostCPU() CPU {
+	return CPU{}
+}
@@ -0,0 +1,30 @@
+// Package platform reports if wazero compiler supports the host, which wazero does not export.
+package platform
+
+// CPU lists CPU features wazero compiler requires.
+type CPU struct {
+	// SSE41 is set if an amd64 CPU supports SSE 4.1 instructions
+	SSE41 bool
+	// Atomics is set if an arm64 CPU supports Large System Extensions atomic instructions
+	Atomics bool
+}
+
+// HostCPU is the CPU of the host.
+var HostCPU = hostCPU()
+
+// CompilerSupports reports if wazero compiler supports the OS, the architecture and the CPU.
+// threads is set if the module uses threads feature, which requires atomic instructions on arm64.
+// It follows the check of wazero v1.9, which picks the interpreter on other platforms.
+func CompilerSupports(goos, goarch string, cpu CPU, threads bool) bool {
+	switch goos {
+	case "linux", "darwin", "freebsd", "netbsd", "dragonfly", "windows":
+		if goarch == "arm64" {
+			return !threads || cpu.Atomics
+		}
+		return goarch == "amd64" && cpu.SSE41
+	case "solaris", "illumos":
+		return goarch == "amd64" && cpu.SSE41
+	default:
+		return false
+	}
+}
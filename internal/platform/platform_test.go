@@ -0,0 +1,31 @@
+package platform
+
+import "testing"
+
+func TestCompilerSupports(t *testing.T) {
+	tests := []struct {
+		name          string
+		goos, goarch  string
+		cpu           CPU
+		threads, want bool
+	}{
+		{name: "linux amd64", goos: "linux", goarch: "amd64", cpu: CPU{SSE41: true}, threads: true, want: true},
+		{name: "amd64 without SSE 4.1", goos: "linux", goarch: "amd64", threads: true},
+		{name: "illumos amd64", goos: "illumos", goarch: "amd64", cpu: CPU{SSE41: true}, want: true},
+		{name: "darwin arm64", goos: "darwin", goarch: "arm64", cpu: CPU{Atomics: true}, threads: true, want: true},
+		{name: "arm64 without atomics", goos: "linux", goarch: "arm64", threads: true},
+		{name: "arm64 without atomics and threads", goos: "linux", goarch: "arm64", want: true},
+		{name: "solaris arm64", goos: "solaris", goarch: "arm64", cpu: CPU{Atomics: true}},
+		{name: "linux 386", goos: "linux", goarch: "386", cpu: CPU{SSE41: true}},
+		{name: "linux riscv64", goos: "linux", goarch: "riscv64"},
+		{name: "js wasm", goos: "js", goarch: "wasm"},
+		{name: "plan9 amd64", goos: "plan9", goarch: "amd64", cpu: CPU{SSE41: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompilerSupports(tt.goos, tt.goarch, tt.cpu, tt.threads); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
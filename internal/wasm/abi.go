@@ -0,0 +1,223 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	embind "github.com/jerbob92/wazero-emscripten-embind"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ErrIncompatibleModule is matched by ABIError.
+var ErrIncompatibleModule = errors.New("incompatible Bergamot WASM module")
+
+// ABIMismatch is a difference between a Bergamot module and the interface the library relies on.
+type ABIMismatch struct {
+	// Kind is one of "import", "export", "class" and "method"
+	Kind string
+	// Name identifies the item, e.g. "wasm_gemm.int8_prepare_a", "malloc", "BlockingService" or "BlockingService.translate"
+	Name string
+	// Reason describes the mismatch, e.g. "missing" or "expected (i32) -> i32, got (i32) -> ()"
+	Reason string
+}
+
+func (m ABIMismatch) String() string {
+	return fmt.Sprintf("%s %s: %s", m.Kind, m.Name, m.Reason)
+}
+
+// ABIError lists all mismatches found in a Bergamot module.
+type ABIError struct {
+	Mismatches []ABIMismatch
+}
+
+func (e *ABIError) Error() string {
+	mismatches := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		mismatches[i] = m.String()
+	}
+	return fmt.Sprintf("%v: %s", ErrIncompatibleModule, strings.Join(mismatches, "; "))
+}
+
+func (e *ABIError) Is(target error) bool {
+	return target == ErrIncompatibleModule
+}
+
+// functionSignature is the signature of a module function.
+type functionSignature struct {
+	params, results []api.ValueType
+}
+
+func signatureOf(def api.FunctionDefinition) functionSignature {
+	return functionSignature{params: def.ParamTypes(), results: def.ResultTypes()}
+}
+
+func (s functionSignature) equal(other functionSignature) bool {
+	return slices.Equal(s.params, other.params) && slices.Equal(s.results, other.results)
+}
+
+func (s functionSignature) String() string {
+	format := func(types []api.ValueType) string {
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = api.ValueTypeName(t)
+		}
+		return "(" + strings.Join(names, ", ") + ")"
+	}
+	return format(s.params) + " -> " + format(s.results)
+}
+
+var (
+	i32 = api.ValueTypeI32
+
+	// requiredExports are functions called by the library and embind besides the fallback gemm ones
+	requiredExports = map[string]functionSignature{
+		"_initialize":   {},
+		"malloc":        {params: []api.ValueType{i32}, results: []api.ValueType{i32}},
+		"free":          {params: []api.ValueType{i32}},
+		"__getTypeName": {params: []api.ValueType{i32}, results: []api.ValueType{i32}},
+	}
+
+	// fallbackGemmExports maps wasm_gemm imports to exported intgemm functions called by FallbackGemm,
+	// which take the same parameters
+	fallbackGemmExports = map[string]string{
+		"int8_prepare_a":                           "int8PrepareAFallback",
+		"int8_prepare_b":                           "int8PrepareBFallback",
+		"int8_prepare_b_from_transposed":           "int8PrepareBFromTransposedFallback",
+		"int8_prepare_b_from_quantized_transposed": "int8PrepareBFromQuantizedTransposedFallback",
+		"int8_prepare_bias":                        "int8PrepareBiasFallback",
+		"int8_multiply_and_add_bias":               "int8MultiplyAndAddBiasFallback",
+		"int8_select_columns_of_b":                 "int8SelectColumnsOfBFallback",
+	}
+
+	// requiredClasses are embind classes registered by gen.Attach with methods the library calls
+	requiredClasses = map[string][]string{
+		"AlignedMemory":         {"getByteArrayView"},
+		"AlignedMemoryList":     {"push_back"},
+		"BlockingService":       {"translate"},
+		"Response":              {"getTranslatedText", "size"},
+		"TranslationModel":      nil,
+		"VectorResponse":        {"get", "size"},
+		"VectorResponseOptions": {"push_back"},
+		"VectorString":          {"push_back"},
+	}
+)
+
+// CheckExports verifies that the compiled module exports everything the library and embind call.
+// It must be called before BuildImports, which fails on some missing exports less precisely.
+// It returns ABIError listing all mismatches.
+func CheckExports(compiledModule wazero.CompiledModule, cfg CompileConfig) error {
+	required := make(map[string]functionSignature, len(requiredExports)+len(fallbackGemmExports))
+	for name, signature := range requiredExports {
+		required[name] = signature
+	}
+	if _, fallback := cfg.GemmBackend.(fallbackGemm); cfg.GemmBackend == nil || fallback {
+		for _, fn := range compiledModule.ImportedFunctions() {
+			moduleName, name, _ := fn.Import()
+			if exported, ok := fallbackGemmExports[name]; ok && moduleName == "wasm_gemm" {
+				required[exported] = signatureOf(fn)
+			}
+		}
+	}
+
+	var mismatches []ABIMismatch
+	exports := compiledModule.ExportedFunctions()
+	for _, name := range sortedKeys(required) {
+		def, ok := exports[name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, ABIMismatch{Kind: "export", Name: name, Reason: "missing"})
+		case !signatureOf(def).equal(required[name]):
+			mismatches = append(mismatches, ABIMismatch{
+				Kind: "export", Name: name,
+				Reason: fmt.Sprintf("expected %v, got %v", required[name], signatureOf(def)),
+			})
+		}
+	}
+	if len(compiledModule.ExportedMemories()) == 0 {
+		mismatches = append(mismatches, ABIMismatch{Kind: "export", Name: "memory", Reason: "missing"})
+	}
+	if len(mismatches) != 0 {
+		return &ABIError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// CheckImports verifies that imports of the compiled module match host functions built by BuildImports.
+// It returns ABIError listing all mismatches.
+func CheckImports(wasmRuntime wazero.Runtime, compiledModule wazero.CompiledModule) error {
+	var mismatches []ABIMismatch
+	for _, mem := range compiledModule.ImportedMemories() {
+		moduleName, name, _ := mem.Import()
+		mismatches = append(mismatches, ABIMismatch{
+			Kind: "import", Name: moduleName + "." + name, Reason: "imported memory is not supported",
+		})
+	}
+	for _, fn := range compiledModule.ImportedFunctions() {
+		moduleName, name, _ := fn.Import()
+		host := wasmRuntime.Module(moduleName)
+		if host == nil {
+			mismatches = append(mismatches, ABIMismatch{
+				Kind: "import", Name: moduleName + "." + name, Reason: "unknown module",
+			})
+			continue
+		}
+		def, ok := host.ExportedFunctionDefinitions()[name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, ABIMismatch{
+				Kind: "import", Name: moduleName + "." + name, Reason: "no such host function",
+			})
+		case !signatureOf(def).equal(signatureOf(fn)):
+			mismatches = append(mismatches, ABIMismatch{
+				Kind: "import", Name: moduleName + "." + name,
+				Reason: fmt.Sprintf("host function is %v, got %v", signatureOf(def), signatureOf(fn)),
+			})
+		}
+	}
+	if len(mismatches) != 0 {
+		return &ABIError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// checkClasses verifies that the instantiated module registered embind classes and methods the library calls.
+func checkClasses(embindEngine embind.Engine) error {
+	classes := make(map[string]map[string]bool)
+	for _, class := range embindEngine.GetClasses() {
+		methods := make(map[string]bool)
+		for _, method := range class.Methods() {
+			methods[method.Symbol()] = true
+		}
+		classes[class.Name()] = methods
+	}
+
+	var mismatches []ABIMismatch
+	for _, name := range sortedKeys(requiredClasses) {
+		methods, ok := classes[name]
+		if !ok {
+			mismatches = append(mismatches, ABIMismatch{Kind: "class", Name: name, Reason: "not registered"})
+			continue
+		}
+		for _, method := range requiredClasses[name] {
+			if !methods[method] {
+				mismatches = append(mismatches, ABIMismatch{Kind: "method", Name: name + "." + method, Reason: "missing"})
+			}
+		}
+	}
+	if len(mismatches) != 0 {
+		return &ABIError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
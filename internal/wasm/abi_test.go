@@ -0,0 +1,186 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	embind "github.com/jerbob92/wazero-emscripten-embind"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+type testFunc struct {
+	module, name    string
+	params, results []api.ValueType
+}
+
+// abiWASM builds a module importing and exporting functions with the given signatures.
+// Exported functions return zeros, so their results must be i32.
+func abiWASM(imports, exports []testFunc, memory bool) []byte {
+	leb := func(b []byte, v uint32) []byte {
+		for {
+			c := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 {
+				return append(b, c)
+			}
+			b = append(b, c|0x80)
+		}
+	}
+	name := func(b []byte, s string) []byte {
+		return append(leb(b, uint32(len(s))), s...)
+	}
+	section := func(b []byte, id byte, content []byte) []byte {
+		return append(leb(append(b, id), uint32(len(content))), content...)
+	}
+	funcs := slices.Concat(imports, exports)
+	numExports := uint32(len(exports))
+	if memory {
+		numExports++
+	}
+
+	var types, importSection, functions, exportSection, code []byte
+	types = leb(types, uint32(len(funcs)))
+	for _, fn := range funcs {
+		types = append(leb(append(types, 0x60), uint32(len(fn.params))), fn.params...)
+		types = append(leb(types, uint32(len(fn.results))), fn.results...)
+	}
+	importSection = leb(importSection, uint32(len(imports)))
+	for i, fn := range imports {
+		importSection = leb(append(name(name(importSection, fn.module), fn.name), 0x00), uint32(i))
+	}
+	functions, exportSection, code = leb(functions, uint32(len(exports))), leb(exportSection, numExports), leb(code, uint32(len(exports)))
+	for i, fn := range exports {
+		index := uint32(len(imports) + i)
+		functions = leb(functions, index)
+		exportSection = leb(append(name(exportSection, fn.name), 0x00), index)
+		body := []byte{0} // no locals
+		for range fn.results {
+			body = append(body, 0x41, 0) // i32.const 0
+		}
+		body = append(body, 0x0b) // end
+		code = append(leb(code, uint32(len(body))), body...)
+	}
+
+	module := []byte{0x00, 'a', 's', 'm', 1, 0, 0, 0}
+	module = section(module, 1, types)
+	module = section(module, 2, importSection)
+	module = section(module, 3, functions)
+	if memory {
+		module = section(module, 5, []byte{1, 0x00, 1})
+		exportSection = append(name(exportSection, "memory"), 0x02, 0)
+	}
+	module = section(module, 7, exportSection)
+	return section(module, 10, code)
+}
+
+func compileABIModule(t *testing.T, ctx context.Context, module []byte) (wazero.Runtime, wazero.CompiledModule) {
+	t.Helper()
+	wasmRuntime := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { wasmRuntime.Close(ctx) })
+	compiled, err := wasmRuntime.CompileModule(ctx, module)
+	if err != nil {
+		t.Fatalf("failed to compile module: %v", err)
+	}
+	return wasmRuntime, compiled
+}
+
+func abiMismatches(t *testing.T, err error) []ABIMismatch {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var abiErr *ABIError
+	if !errors.As(err, &abiErr) || !errors.Is(err, ErrIncompatibleModule) {
+		t.Fatalf("expected ABIError, got %v", err)
+	}
+	return abiErr.Mismatches
+}
+
+var requiredTestExports = []testFunc{
+	{name: "_initialize"},
+	{name: "malloc", params: valueTypes("i"), results: valueTypes("i")},
+	{name: "free", params: valueTypes("i")},
+	{name: "__getTypeName", params: valueTypes("i"), results: valueTypes("i")},
+}
+
+func TestCheckExports(t *testing.T) {
+	prepareImport := testFunc{module: "wasm_gemm", name: "int8_prepare_b_from_quantized_transposed", params: valueTypes("iiii")}
+	tests := []struct {
+		name    string
+		exports []testFunc
+		memory  bool
+		backend GemmBackend
+		want    []ABIMismatch
+	}{
+		{
+			name: "compatible",
+			exports: slices.Concat(requiredTestExports, []testFunc{
+				{name: "int8PrepareBFromQuantizedTransposedFallback", params: valueTypes("iiii")},
+			}),
+			memory: true,
+		},
+		{
+			name:    "native gemm",
+			exports: requiredTestExports,
+			memory:  true,
+			backend: NativeGemm(),
+		},
+		{
+			name: "mismatched",
+			exports: []testFunc{
+				{name: "_initialize", params: valueTypes("i")},
+				{name: "free", params: valueTypes("i")},
+				{name: "int8PrepareBFromQuantizedTransposedFallback", params: valueTypes("iii")},
+			},
+			backend: FallbackGemm(),
+			want: []ABIMismatch{
+				{Kind: "export", Name: "__getTypeName", Reason: "missing"},
+				{Kind: "export", Name: "_initialize", Reason: "expected () -> (), got (i32) -> ()"},
+				{
+					Kind: "export", Name: "int8PrepareBFromQuantizedTransposedFallback",
+					Reason: "expected (i32, i32, i32, i32) -> (), got (i32, i32, i32) -> ()",
+				},
+				{Kind: "export", Name: "malloc", Reason: "missing"},
+				{Kind: "export", Name: "memory", Reason: "missing"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, compiled := compileABIModule(t, context.Background(), abiWASM([]testFunc{prepareImport}, tt.exports, tt.memory))
+			got := abiMismatches(t, CheckExports(compiled, CompileConfig{GemmBackend: tt.backend}))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected mismatches:\n%v\ngot:\n%v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckImports(t *testing.T) {
+	ctx := context.Background()
+	module := abiWASM([]testFunc{
+		{module: "wasm_gemm", name: "int8_prepare_b_from_quantized_transposed", params: valueTypes("iii")},
+		{module: "wasi_snapshot_preview1", name: "fd_close", params: valueTypes("i"), results: valueTypes("i")},
+		{module: "env", name: "missing"},
+		{module: "unknown", name: "f"},
+	}, requiredTestExports, true)
+	wasmRuntime, compiled := compileABIModule(t, ctx, module)
+	if err := BuildImports(ctx, wasmRuntime, embind.CreateEngine(embind.NewConfig()), compiled, CompileConfig{}); err != nil {
+		t.Fatalf("failed to build imports: %v", err)
+	}
+
+	want := []ABIMismatch{
+		{
+			Kind: "import", Name: "wasm_gemm.int8_prepare_b_from_quantized_transposed",
+			Reason: "host function is (i32, i32, i32, i32) -> (), got (i32, i32, i32) -> ()",
+		},
+		{Kind: "import", Name: "env.missing", Reason: "no such host function"},
+		{Kind: "import", Name: "unknown.f", Reason: "unknown module"},
+	}
+	if got := abiMismatches(t, CheckImports(wasmRuntime, compiled)); !slices.Equal(got, want) {
+		t.Errorf("expected mismatches:\n%v\ngot:\n%v", want, got)
+	}
+}
@@ -0,0 +1,344 @@
+package wasm
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FunctionSource locates a module function in C++ sources.
+type FunctionSource struct {
+	// Name is the function name qualified with its namespaces and classes, e.g. "marian::bergamot::BlockingService::translateMultiple"
+	Name string
+	File string
+	Line int64
+}
+
+var errMalformedModule = errors.New("malformed WASM module")
+
+// FunctionSources reads DWARF sections of the module and returns sources of its functions
+// by their indexes in the function index space, which starts with imported functions.
+// It returns nil if the module has no DWARF sections, which is the case for the release build.
+func FunctionSources(module []byte) (map[uint32]FunctionSource, error) {
+	sections, err := parseSections(module)
+	if err != nil {
+		return nil, err
+	}
+	if sections.custom[".debug_info"] == nil {
+		return nil, nil
+	}
+
+	custom := sections.custom
+	data, err := dwarf.New(custom[".debug_abbrev"], custom[".debug_aranges"], nil, custom[".debug_info"],
+		custom[".debug_line"], custom[".debug_pubnames"], custom[".debug_ranges"], custom[".debug_str"])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{".debug_addr", ".debug_line_str", ".debug_rnglists", ".debug_str_offsets"} {
+		if section := custom[name]; section != nil {
+			if err := data.AddSection(name, section); err != nil {
+				return nil, err
+			}
+		}
+	}
+	subprograms, err := readSubprograms(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[uint32]FunctionSource, len(sections.bodies))
+	for i, body := range sections.bodies {
+		// DWARF addresses are offsets in the code section, a body must be inside the function range
+		j := sort.Search(len(subprograms), func(j int) bool { return subprograms[j].high > body })
+		if j < len(subprograms) && subprograms[j].low <= body {
+			sources[sections.importedFuncs+uint32(i)] = subprograms[j].FunctionSource
+		}
+	}
+	return sources, nil
+}
+
+type moduleSections struct {
+	custom        map[string][]byte
+	importedFuncs uint32
+	// bodies are offsets of function bodies in the code section
+	bodies []uint64
+}
+
+// parseSections reads custom sections, the number of imported functions and offsets of function bodies.
+func parseSections(module []byte) (moduleSections, error) {
+	const headerSize = 8
+	if len(module) < headerSize || !bytes.Equal(module[:4], []byte("\x00asm")) {
+		return moduleSections{}, errMalformedModule
+	}
+	sections := moduleSections{custom: make(map[string][]byte)}
+	r := module[headerSize:]
+	for len(r) != 0 {
+		id := r[0]
+		size, n := binary.Uvarint(r[1:])
+		if n <= 0 || uint64(len(r)-1-n) < size {
+			return moduleSections{}, errMalformedModule
+		}
+		content := r[1+n : 1+n+int(size)]
+		r = r[1+n+int(size):]
+
+		var err error
+		switch id {
+		case 0:
+			nameLen, n := binary.Uvarint(content)
+			if n <= 0 || uint64(len(content)-n) < nameLen {
+				return moduleSections{}, errMalformedModule
+			}
+			sections.custom[string(content[n:n+int(nameLen)])] = content[n+int(nameLen):]
+		case 2:
+			sections.importedFuncs, err = countImports(content, externFunction)
+		case 10:
+			sections.bodies, err = functionBodies(content)
+		}
+		if err != nil {
+			return moduleSections{}, err
+		}
+	}
+	return sections, nil
+}
+
+// External kinds of imports and exports.
+const (
+	externFunction = 0x00
+	externGlobal   = 0x03
+)
+
+// countImports returns the number of imports of the given kind.
+func countImports(content []byte, want byte) (uint32, error) {
+	count, n := binary.Uvarint(content)
+	if n <= 0 {
+		return 0, errMalformedModule
+	}
+	r := content[n:]
+	skipName := func() bool {
+		size, n := binary.Uvarint(r)
+		if n <= 0 || uint64(len(r)-n) < size {
+			return false
+		}
+		r = r[n+int(size):]
+		return true
+	}
+	skipUvarints := func(k int) bool {
+		for range k {
+			_, n := binary.Uvarint(r)
+			if n <= 0 {
+				return false
+			}
+			r = r[n:]
+		}
+		return true
+	}
+
+	var imports uint32
+	for range count {
+		if !skipName() || !skipName() || len(r) == 0 {
+			return 0, errMalformedModule
+		}
+		kind := r[0]
+		r = r[1:]
+		if kind == want {
+			imports++
+		}
+		ok := true
+		switch kind {
+		case externFunction: // function: type index
+			ok = skipUvarints(1)
+		case 0x01: // table: reference type and limits
+			ok = len(r) > 1
+			if ok {
+				flags := r[1]
+				r = r[2:]
+				ok = skipUvarints(1 + int(flags&1))
+			}
+		case 0x02: // memory: limits
+			ok = len(r) > 0
+			if ok {
+				flags := r[0]
+				r = r[1:]
+				ok = skipUvarints(1 + int(flags&1))
+			}
+		case externGlobal: // global: value type and mutability
+			ok = len(r) >= 2
+			if ok {
+				r = r[2:]
+			}
+		case 0x04: // tag: attribute and type index
+			ok = len(r) > 0
+			if ok {
+				r = r[1:]
+				ok = skipUvarints(1)
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return 0, errMalformedModule
+		}
+	}
+	return imports, nil
+}
+
+func functionBodies(content []byte) ([]uint64, error) {
+	count, n := binary.Uvarint(content)
+	if n <= 0 {
+		return nil, errMalformedModule
+	}
+	bodies := make([]uint64, 0, count)
+	offset := uint64(n)
+	for range count {
+		size, n := binary.Uvarint(content[offset:])
+		if n <= 0 || uint64(len(content))-offset-uint64(n) < size {
+			return nil, errMalformedModule
+		}
+		offset += uint64(n)
+		bodies = append(bodies, offset)
+		offset += size
+	}
+	return bodies, nil
+}
+
+// deadCodeAddress and greater addresses are tombstones of functions removed by the linker.
+const deadCodeAddress = 0xfffffffe
+
+type subprogram struct {
+	FunctionSource
+	low, high uint64
+	offset    dwarf.Offset
+}
+
+// readSubprograms returns code ranges of functions sorted by their start.
+func readSubprograms(data *dwarf.Data) ([]subprogram, error) {
+	type declaration struct {
+		FunctionSource
+		// ref is the declaration of a definition without name
+		ref dwarf.Offset
+	}
+	var (
+		declarations = make(map[dwarf.Offset]declaration)
+		// definitions are subprograms having code, which refer to their declarations by offset
+		definitions []subprogram
+		files       []*dwarf.LineFile
+		// scopes are names of entries enclosing the current one, empty for not named ones
+		scopes []string
+	)
+
+	r := data.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag == 0 {
+			if len(scopes) != 0 {
+				scopes = scopes[:len(scopes)-1]
+			}
+			continue
+		}
+
+		name, _ := entry.Val(dwarf.AttrName).(string)
+		switch entry.Tag {
+		case dwarf.TagCompileUnit:
+			files = nil
+			if lr, err := data.LineReader(entry); err == nil && lr != nil {
+				files = lr.Files()
+			}
+		case dwarf.TagSubprogram:
+			decl := declaration{FunctionSource: FunctionSource{Name: qualifiedName(scopes, name)}}
+			if line, ok := entry.Val(dwarf.AttrDeclLine).(int64); ok {
+				decl.Line = line
+			}
+			if file, ok := entry.Val(dwarf.AttrDeclFile).(int64); ok && file >= 0 && int(file) < len(files) && files[file] != nil {
+				decl.File = files[file].Name
+			}
+			if name == "" {
+				for _, attr := range []dwarf.Attr{dwarf.AttrSpecification, dwarf.AttrAbstractOrigin} {
+					if ref, ok := entry.Val(attr).(dwarf.Offset); ok {
+						decl.ref = ref
+						break
+					}
+				}
+			}
+			declarations[entry.Offset] = decl
+
+			// functions removed by the linker have zero or tombstone addresses
+			low, lowOK := entry.Val(dwarf.AttrLowpc).(uint64)
+			if field := entry.AttrField(dwarf.AttrHighpc); lowOK && low != 0 && low < deadCodeAddress && field != nil {
+				high, _ := field.Val.(uint64)
+				if field.Class == dwarf.ClassConstant {
+					offset, _ := field.Val.(int64)
+					high = low + uint64(offset)
+				}
+				definitions = append(definitions, subprogram{low: low, high: high, offset: entry.Offset})
+			}
+		}
+
+		if entry.Children {
+			switch entry.Tag {
+			case dwarf.TagNamespace, dwarf.TagClassType, dwarf.TagStructType, dwarf.TagUnionType:
+				scopes = append(scopes, name)
+			default:
+				scopes = append(scopes, "")
+			}
+		}
+	}
+
+	subprograms := definitions[:0]
+	for _, def := range definitions {
+		source := declarations[def.offset]
+		// definitions refer to declarations, which may refer to abstract instances
+		for ref, depth := source.ref, 0; ref != 0 && depth < 4; depth++ {
+			refSource, ok := declarations[ref]
+			if !ok {
+				break
+			}
+			if source.Name == "" {
+				source.Name = refSource.Name
+			}
+			if source.File == "" {
+				source.File, source.Line = refSource.File, refSource.Line
+			}
+			ref = refSource.ref
+		}
+		if source.Name == "" {
+			continue
+		}
+		def.FunctionSource = source.FunctionSource
+		subprograms = append(subprograms, def)
+	}
+	slices.SortFunc(subprograms, func(a, b subprogram) int {
+		switch {
+		case a.low < b.low:
+			return -1
+		case a.low > b.low:
+			return 1
+		}
+		return 0
+	})
+	return subprograms, nil
+}
+
+func qualifiedName(scopes []string, name string) string {
+	if name == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, scope := range scopes {
+		if scope != "" {
+			b.WriteString(scope)
+			b.WriteString("::")
+		}
+	}
+	b.WriteString(name)
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"maps"
+	"testing"
+)
+
+// customSection encodes a custom section to append to a module.
+func customSection(name string, content []byte) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(name)))
+	payload = append(append(payload, name...), content...)
+	section := binary.AppendUvarint([]byte{0}, uint64(len(payload)))
+	return append(section, payload...)
+}
+
+// debugSections builds DWARF 4 sections describing function ns::f at code offsets [1, 4)
+// and method ns::C::m declared in class C and defined out of line at [4, 7).
+func debugSections() []byte {
+	abbrev := []byte{
+		1, 0x11, 1, 0, 0, // compile unit with children, no attributes
+		2, 0x39, 1, 0x03, 0x08, 0, 0, // namespace with children: name string
+		3, 0x2e, 0, 0x03, 0x08, 0x11, 0x01, 0x12, 0x06, 0x3b, 0x0b, 0, 0, // subprogram: name, low_pc, high_pc data4, decl_line
+		4, 0x02, 1, 0x03, 0x08, 0, 0, // class with children: name
+		5, 0x2e, 0, 0x03, 0x08, 0x3c, 0x19, 0x3b, 0x0b, 0, 0, // subprogram declaration: name, declaration, decl_line
+		6, 0x2e, 0, 0x47, 0x13, 0x11, 0x01, 0x12, 0x06, 0, 0, // subprogram definition: specification ref4, low_pc, high_pc data4
+		0,
+	}
+
+	const headerSize = 11 // unit length, version, abbrev offset, address size
+	u32 := func(b []byte, v uint32) []byte { return binary.LittleEndian.AppendUint32(b, v) }
+	var entries []byte
+	entries = append(entries, 1)              // compile unit
+	entries = append(entries, 2, 'n', 's', 0) // namespace ns
+	entries = append(entries, 3, 'f', 0)      // ns::f
+	entries = append(u32(u32(entries, 1), 3), 10)
+	entries = append(entries, 4, 'C', 0) // class C
+	declaration := headerSize + len(entries)
+	entries = append(entries, 5, 'm', 0, 20) // C::m declaration
+	entries = append(entries, 0)             // end of C
+	entries = append(entries, 6)             // C::m definition
+	entries = u32(u32(u32(entries, uint32(declaration)), 4), 3)
+	entries = append(entries, 0, 0) // end of ns and compile unit
+
+	info := u32(nil, uint32(headerSize-4+len(entries)))
+	info = binary.LittleEndian.AppendUint16(info, 4)
+	info = append(u32(info, 0), 4)
+	info = append(info, entries...)
+	return append(customSection(".debug_abbrev", abbrev), customSection(".debug_info", info)...)
+}
+
+func TestFunctionSources(t *testing.T) {
+	module := abiWASM(
+		[]testFunc{{module: "env", name: "imported"}},
+		[]testFunc{{name: "f"}, {name: "m"}},
+		false,
+	)
+
+	sources, err := FunctionSources(module)
+	if err != nil {
+		t.Fatalf("failed to read module without DWARF: %v", err)
+	}
+	if sources != nil {
+		t.Errorf("expected no sources for module without DWARF, got %v", sources)
+	}
+
+	sources, err = FunctionSources(append(module, debugSections()...))
+	if err != nil {
+		t.Fatalf("failed to read DWARF: %v", err)
+	}
+	want := map[uint32]FunctionSource{
+		1: {Name: "ns::f", Line: 10},
+		2: {Name: "ns::C::m", Line: 20},
+	}
+	if !maps.Equal(sources, want) {
+		t.Errorf("expected sources %v, got %v", want, sources)
+	}
+
+	if _, err := FunctionSources(module[:len(module)-1]); err == nil {
+		t.Error("expected error for truncated module")
+	}
+}
@@ -0,0 +1,30 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnimplemented is reported by host functions the module imports but must never call.
+var ErrUnimplemented = errors.New("unimplemented host func")
+
+// HostFuncError is raised as a panic by host functions to abort the calling module function.
+// wazero wraps the panic value into the error returned by the module function call,
+// so it can be retrieved with errors.As.
+type HostFuncError struct {
+	// Module and Func are the import module and function names, e.g. "wasm_gemm" and "int8_prepare_a"
+	Module, Func string
+	Err          error
+}
+
+func (e *HostFuncError) Error() string {
+	return fmt.Sprintf("host function %s.%s: %v", e.Module, e.Func, e.Err)
+}
+
+func (e *HostFuncError) Unwrap() error {
+	return e.Err
+}
+
+func unimplemented(module, name string) *HostFuncError {
+	return &HostFuncError{Module: module, Func: name, Err: ErrUnimplemented}
+}
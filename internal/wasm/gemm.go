@@ -0,0 +1,479 @@
+package wasm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/xxnuo/gobergamot/internal/gemm"
+)
+
+// GemmBackend implements int8 matrix multiplication functions imported by Marian from wasm_gemm module.
+// Methods correspond to functions of
+// https://github.com/browsermt/marian-dev/blob/master/src/tensors/cpu/wasm_intgemm_interface.h
+// with the same parameters. Pointer parameters are offsets in the linear memory of mod,
+// which is the module calling the function. Prepared A, B and bias are passed only between
+// methods of the same backend, so their layouts are up to the backend.
+type GemmBackend interface {
+	Int8PrepareA(
+		ctx context.Context, mod api.Module,
+		inputA uint32, scale, zeroPoint float32, rowsA, width uint32, output uint32,
+	) error
+	Int8PrepareB(
+		ctx context.Context, mod api.Module,
+		inputB uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+	) error
+	Int8PrepareBFromTransposed(
+		ctx context.Context, mod api.Module,
+		inputBTransposed uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+	) error
+	Int8PrepareBFromQuantizedTransposed(
+		ctx context.Context, mod api.Module,
+		inputBQuantTransposed uint32, width, colsB uint32, output uint32,
+	) error
+	Int8PrepareBias(
+		ctx context.Context, mod api.Module,
+		inputBPrepared uint32, scaleA, zeroPointA, scaleB, zeroPointB float32, width, colsB uint32,
+		inputBias uint32, output uint32,
+	) error
+	Int8MultiplyAndAddBias(
+		ctx context.Context, mod api.Module,
+		inputAPrepared uint32, scaleA, zeroPointA float32,
+		inputBPrepared uint32, scaleB, zeroPointB float32,
+		inputBiasPrepared uint32, unquantMultiplier float32,
+		rowsA, width, colsB uint32, output uint32,
+	) error
+	Int8SelectColumnsOfB(
+		ctx context.Context, mod api.Module,
+		inputBPrepared uint32, width, colsB uint32, cols uint32, numCols uint32, output uint32,
+	) error
+}
+
+// exportGemm exports wasm_gemm functions calling the backend.
+// Functions panic with HostFuncError upon backend errors, which makes wazero abort the calling module function.
+func exportGemm(gemmModule wazero.HostModuleBuilder, backend GemmBackend) {
+	gemmModule.NewFunctionBuilder().WithFunc(func(
+		ctx context.Context,
+		mod api.Module,
+		inputA uint32,
+		scale float32,
+		zeroPoint float32,
+		rowsA uint32,
+		width uint32,
+		output uint32,
+	) {
+		must("int8_prepare_a", backend.Int8PrepareA(ctx, mod, inputA, scale, zeroPoint, rowsA, width, output))
+	}).Export("int8_prepare_a")
+
+	gemmModule.NewFunctionBuilder().WithFunc(func(
+		ctx context.Context,
+		mod api.Module,
+		inputB uint32,
+		scale float32,
+		zeroPoint float32,
+		width uint32,
+		colsB uint32,
+		output uint32,
+	) {
+		must("int8_prepare_b", backend.Int8PrepareB(ctx, mod, inputB, scale, zeroPoint, width, colsB, output))
+	}).Export("int8_prepare_b")
+
+	gemmModule.NewFunctionBuilder().WithFunc(func(
+		ctx context.Context,
+		mod api.Module,
+		inputBTransposed uint32,
+		scale float32,
+		zeroPoint float32,
+		width uint32,
+		colsB uint32,
+		output uint32,
+	) {
+		must("int8_prepare_b_from_transposed",
+			backend.Int8PrepareBFromTransposed(ctx, mod, inputBTransposed, scale, zeroPoint, width, colsB, output))
+	}).Export("int8_prepare_b_from_transposed")
+
+	gemmModule.NewFunctionBuilder().WithFunc(func(
+		ctx context.Context,
+		mod api.Module,
+		inputBQuantTransposed uint32,
+		width uint32,
+		colsB uint32,
+		output uint32,
+	) {
+		must("int8_prepare_b_from_quantized_transposed",
+			backend.Int8PrepareBFromQuantizedTransposed(ctx, mod, inputBQuantTransposed, width, colsB, output))
+	}).Export("int8_prepare_b_from_quantized_transposed")
+
+	gemmModule.NewFunctionBuilder().WithFunc(func(
+		ctx context.Context,
+		mod api.Module,
+		inputBPrepared uint32,
+		scaleA float32,
+		zeroPointA float32,
+		scaleB float32,
+		zeroPointB float32,
+		width uint32,
+		colsB uint32,
+		inputBias uint32,
+		output uint32,
+	) {
+		must("int8_prepare_bias", backend.Int8PrepareBias(ctx, mod, inputBPrepared, scaleA, zeroPointA, scaleB, zeroPointB,
+			width, colsB, inputBias, output))
+	}).Export("int8_prepare_bias")
+
+	gemmModule.NewFunctionBuilder().WithFunc(func(
+		ctx context.Context,
+		mod api.Module,
+		inputAPrepared uint32,
+		scaleA float32,
+		zeroPointA float32,
+		inputBPrepared uint32,
+		scaleB float32,
+		zeroPointB float32,
+		inputBiasPrepared uint32,
+		unquantMultiplier float32,
+		rowsA uint32,
+		width uint32,
+		colsB uint32,
+		output uint32,
+	) {
+		must("int8_multiply_and_add_bias", backend.Int8MultiplyAndAddBias(ctx, mod,
+			inputAPrepared, scaleA, zeroPointA,
+			inputBPrepared, scaleB, zeroPointB,
+			inputBiasPrepared, unquantMultiplier,
+			rowsA, width, colsB, output,
+		))
+	}).Export("int8_multiply_and_add_bias")
+
+	gemmModule.NewFunctionBuilder().WithFunc(func(
+		ctx context.Context,
+		mod api.Module,
+		inputBPrepared uint32,
+		width uint32,
+		colsB uint32,
+		cols uint32,
+		numCols uint32,
+		output uint32,
+	) {
+		must("int8_select_columns_of_b",
+			backend.Int8SelectColumnsOfB(ctx, mod, inputBPrepared, width, colsB, cols, numCols, output))
+	}).Export("int8_select_columns_of_b")
+}
+
+func must(name string, err error) {
+	if err != nil {
+		panic(&HostFuncError{Module: "wasm_gemm", Func: name, Err: err})
+	}
+}
+
+// FallbackGemm returns GemmBackend calling fallback functions exported by Bergamot module,
+// which are intgemm compiled to WASM.
+func FallbackGemm() GemmBackend {
+	return fallbackGemm{}
+}
+
+type fallbackGemm struct{}
+
+func (fallbackGemm) Int8PrepareA(
+	ctx context.Context, mod api.Module,
+	inputA uint32, scale, zeroPoint float32, rowsA, width uint32, output uint32,
+) error {
+	return callFallback(ctx, mod, "int8PrepareAFallback",
+		api.EncodeU32(inputA), api.EncodeF32(scale), api.EncodeF32(zeroPoint),
+		api.EncodeU32(rowsA), api.EncodeU32(width), api.EncodeU32(output))
+}
+
+func (fallbackGemm) Int8PrepareB(
+	ctx context.Context, mod api.Module,
+	inputB uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+) error {
+	return callFallback(ctx, mod, "int8PrepareBFallback",
+		api.EncodeU32(inputB), api.EncodeF32(scale), api.EncodeF32(zeroPoint),
+		api.EncodeU32(width), api.EncodeU32(colsB), api.EncodeU32(output))
+}
+
+func (fallbackGemm) Int8PrepareBFromTransposed(
+	ctx context.Context, mod api.Module,
+	inputBTransposed uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+) error {
+	return callFallback(ctx, mod, "int8PrepareBFromTransposedFallback",
+		api.EncodeU32(inputBTransposed), api.EncodeF32(scale), api.EncodeF32(zeroPoint),
+		api.EncodeU32(width), api.EncodeU32(colsB), api.EncodeU32(output))
+}
+
+func (fallbackGemm) Int8PrepareBFromQuantizedTransposed(
+	ctx context.Context, mod api.Module,
+	inputBQuantTransposed uint32, width, colsB uint32, output uint32,
+) error {
+	return callFallback(ctx, mod, "int8PrepareBFromQuantizedTransposedFallback",
+		api.EncodeU32(inputBQuantTransposed), api.EncodeU32(width), api.EncodeU32(colsB), api.EncodeU32(output))
+}
+
+func (fallbackGemm) Int8PrepareBias(
+	ctx context.Context, mod api.Module,
+	inputBPrepared uint32, scaleA, zeroPointA, scaleB, zeroPointB float32, width, colsB uint32,
+	inputBias uint32, output uint32,
+) error {
+	return callFallback(ctx, mod, "int8PrepareBiasFallback",
+		api.EncodeU32(inputBPrepared),
+		api.EncodeF32(scaleA), api.EncodeF32(zeroPointA), api.EncodeF32(scaleB), api.EncodeF32(zeroPointB),
+		api.EncodeU32(width), api.EncodeU32(colsB), api.EncodeU32(inputBias), api.EncodeU32(output))
+}
+
+func (fallbackGemm) Int8MultiplyAndAddBias(
+	ctx context.Context, mod api.Module,
+	inputAPrepared uint32, scaleA, zeroPointA float32,
+	inputBPrepared uint32, scaleB, zeroPointB float32,
+	inputBiasPrepared uint32, unquantMultiplier float32,
+	rowsA, width, colsB uint32, output uint32,
+) error {
+	return callFallback(ctx, mod, "int8MultiplyAndAddBiasFallback",
+		api.EncodeU32(inputAPrepared), api.EncodeF32(scaleA), api.EncodeF32(zeroPointA),
+		api.EncodeU32(inputBPrepared), api.EncodeF32(scaleB), api.EncodeF32(zeroPointB),
+		api.EncodeU32(inputBiasPrepared), api.EncodeF32(unquantMultiplier),
+		api.EncodeU32(rowsA), api.EncodeU32(width), api.EncodeU32(colsB),
+		api.EncodeU32(output),
+	)
+}
+
+func (fallbackGemm) Int8SelectColumnsOfB(
+	ctx context.Context, mod api.Module,
+	inputBPrepared uint32, width, colsB uint32, cols uint32, numCols uint32, output uint32,
+) error {
+	return callFallback(ctx, mod, "int8SelectColumnsOfBFallback",
+		api.EncodeU32(inputBPrepared), api.EncodeU32(width), api.EncodeU32(colsB),
+		api.EncodeU32(cols), api.EncodeU32(numCols),
+		api.EncodeU32(output),
+	)
+}
+
+func callFallback(ctx context.Context, mod api.Module, name string, params ...uint64) error {
+	fn := mod.ExportedFunction(name)
+	if fn == nil {
+		return fmt.Errorf("fallback function %s is not exported", name)
+	}
+	if _, err := fn.Call(ctx, params...); err != nil {
+		return fmt.Errorf("failed to call fallback function %s: %w", name, err)
+	}
+	return nil
+}
+
+// NativeGemm returns GemmBackend implemented in Go with AVX2 on amd64 and NEON on arm64.
+func NativeGemm() GemmBackend {
+	return memoryGemm{impl: gemm.Native{}}
+}
+
+// ReferenceGemm returns GemmBackend implemented in Go with plain loops.
+// It is slow and is meant to check other backends.
+func ReferenceGemm() GemmBackend {
+	return memoryGemm{impl: gemm.Reference{}}
+}
+
+// errBigEndian is returned by memoryGemm on big endian hosts, where WASM numbers can not be viewed directly.
+var errBigEndian = errors.New("Go gemm backends require little endian host")
+
+// littleEndian defines if WASM linear memory (always little endian) can be viewed as host numbers directly.
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// memoryGemm implements GemmBackend with gemm.Implementation operating on the module linear memory.
+// Marian calls the functions synchronously and they do not call back into the module, so the memory
+// can not be grown and views taken from it stay valid until the function returns.
+type memoryGemm struct {
+	impl gemm.Implementation
+}
+
+func (g memoryGemm) Int8PrepareA(
+	ctx context.Context, mod api.Module,
+	inputA uint32, scale, zeroPoint float32, rowsA, width uint32, output uint32,
+) error {
+	m, err := newMemory(mod)
+	if err != nil {
+		return err
+	}
+	n := size(rowsA, width)
+	in, out := readFloats(m, inputA, n), readBytes(m, output, n)
+	if in == nil || out == nil {
+		return m.err
+	}
+	g.impl.PrepareA(in, scale, int(rowsA), int(width), out)
+	return nil
+}
+
+func (g memoryGemm) Int8PrepareB(
+	ctx context.Context, mod api.Module,
+	inputB uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+) error {
+	m, err := newMemory(mod)
+	if err != nil {
+		return err
+	}
+	n := size(width, colsB)
+	in, out := readFloats(m, inputB, n), readInt8s(m, output, n)
+	if in == nil || out == nil {
+		return m.err
+	}
+	g.impl.PrepareB(in, scale, int(width), int(colsB), out)
+	return nil
+}
+
+func (g memoryGemm) Int8PrepareBFromTransposed(
+	ctx context.Context, mod api.Module,
+	inputBTransposed uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+) error {
+	m, err := newMemory(mod)
+	if err != nil {
+		return err
+	}
+	n := size(width, colsB)
+	in, out := readFloats(m, inputBTransposed, n), readInt8s(m, output, n)
+	if in == nil || out == nil {
+		return m.err
+	}
+	g.impl.PrepareBFromTransposed(in, scale, int(width), int(colsB), out)
+	return nil
+}
+
+func (g memoryGemm) Int8PrepareBFromQuantizedTransposed(
+	ctx context.Context, mod api.Module,
+	inputBQuantTransposed uint32, width, colsB uint32, output uint32,
+) error {
+	m, err := newMemory(mod)
+	if err != nil {
+		return err
+	}
+	n := size(width, colsB)
+	in, out := readInt8s(m, inputBQuantTransposed, n), readInt8s(m, output, n)
+	if in == nil || out == nil {
+		return m.err
+	}
+	g.impl.PrepareBFromQuantizedTransposed(in, int(width), int(colsB), out)
+	return nil
+}
+
+func (g memoryGemm) Int8PrepareBias(
+	ctx context.Context, mod api.Module,
+	inputBPrepared uint32, scaleA, zeroPointA, scaleB, zeroPointB float32, width, colsB uint32,
+	inputBias uint32, output uint32,
+) error {
+	m, err := newMemory(mod)
+	if err != nil {
+		return err
+	}
+	in := readInt8s(m, inputBPrepared, size(width, colsB))
+	bias, out := readFloats(m, inputBias, uint64(colsB)), readFloats(m, output, uint64(colsB))
+	if in == nil || bias == nil || out == nil {
+		return m.err
+	}
+	g.impl.PrepareBias(in, scaleA, scaleB, int(width), int(colsB), bias, out)
+	return nil
+}
+
+func (g memoryGemm) Int8MultiplyAndAddBias(
+	ctx context.Context, mod api.Module,
+	inputAPrepared uint32, scaleA, zeroPointA float32,
+	inputBPrepared uint32, scaleB, zeroPointB float32,
+	inputBiasPrepared uint32, unquantMultiplier float32,
+	rowsA, width, colsB uint32, output uint32,
+) error {
+	m, err := newMemory(mod)
+	if err != nil {
+		return err
+	}
+	a, b := readBytes(m, inputAPrepared, size(rowsA, width)), readInt8s(m, inputBPrepared, size(width, colsB))
+	bias, out := readFloats(m, inputBiasPrepared, uint64(colsB)), readFloats(m, output, size(rowsA, colsB))
+	if a == nil || b == nil || bias == nil || out == nil {
+		return m.err
+	}
+	g.impl.MultiplyAndAddBias(a, scaleA, b, scaleB, bias, unquantMultiplier, int(rowsA), int(width), int(colsB), out)
+	return nil
+}
+
+func (g memoryGemm) Int8SelectColumnsOfB(
+	ctx context.Context, mod api.Module,
+	inputBPrepared uint32, width, colsB uint32, cols uint32, numCols uint32, output uint32,
+) error {
+	m, err := newMemory(mod)
+	if err != nil {
+		return err
+	}
+	in, selected := readInt8s(m, inputBPrepared, size(width, colsB)), readUint32s(m, cols, uint64(numCols))
+	out := readInt8s(m, output, size(width, numCols))
+	if in == nil || selected == nil || out == nil {
+		return m.err
+	}
+	for _, col := range selected {
+		if col >= colsB {
+			return fmt.Errorf("selected column %d is out of %d columns", col, colsB)
+		}
+	}
+	g.impl.SelectColumnsOfB(in, int(width), int(colsB), selected, out)
+	return nil
+}
+
+// memory provides views of the module linear memory. The first failed read is kept in err
+// and makes the following reads return nil.
+type memory struct {
+	mem api.Memory
+	err error
+}
+
+func newMemory(mod api.Module) (*memory, error) {
+	if !littleEndian {
+		return nil, errBigEndian
+	}
+	mem := mod.Memory()
+	if mem == nil {
+		return nil, errors.New("module has no memory")
+	}
+	return &memory{mem: mem}, nil
+}
+
+func size(a, b uint32) uint64 {
+	return uint64(a) * uint64(b)
+}
+
+// readBytes returns a view of n bytes of the memory at ptr or nil if they are out of memory range.
+func readBytes(m *memory, ptr uint32, n uint64) []byte {
+	if m.err != nil {
+		return nil
+	}
+	if n > uint64(m.mem.Size()) {
+		m.err = fmt.Errorf("%d bytes at %d are out of memory range", n, ptr)
+		return nil
+	}
+	buf, ok := m.mem.Read(ptr, uint32(n))
+	if !ok {
+		m.err = fmt.Errorf("%d bytes at %d are out of memory range", n, ptr)
+		return nil
+	}
+	return buf
+}
+
+func readInt8s(m *memory, ptr uint32, n uint64) []int8 {
+	buf := readBytes(m, ptr, n)
+	if buf == nil {
+		return nil
+	}
+	return unsafe.Slice((*int8)(unsafe.Pointer(unsafe.SliceData(buf))), len(buf))
+}
+
+func readFloats(m *memory, ptr uint32, n uint64) []float32 {
+	buf := readBytes(m, ptr, n*4)
+	if buf == nil {
+		return nil
+	}
+	return unsafe.Slice((*float32)(unsafe.Pointer(unsafe.SliceData(buf))), n)
+}
+
+func readUint32s(m *memory, ptr uint32, n uint64) []uint32 {
+	buf := readBytes(m, ptr, n*4)
+	if buf == nil {
+		return nil
+	}
+	return unsafe.Slice((*uint32)(unsafe.Pointer(unsafe.SliceData(buf))), n)
+}
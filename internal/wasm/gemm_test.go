@@ -0,0 +1,459 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	embind "github.com/jerbob92/wazero-emscripten-embind"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// gemmImports lists parameters of wasm_gemm functions as Bergamot module imports them.
+var gemmImports = []struct {
+	name   string
+	params []api.ValueType
+}{
+	{name: "int8_prepare_a", params: valueTypes("iffiii")},
+	{name: "int8_prepare_b", params: valueTypes("iffiii")},
+	{name: "int8_prepare_b_from_transposed", params: valueTypes("iffiii")},
+	{name: "int8_prepare_b_from_quantized_transposed", params: valueTypes("iiii")},
+	{name: "int8_prepare_bias", params: valueTypes("iffffiiii")},
+	{name: "int8_multiply_and_add_bias", params: valueTypes("iffiffifiiii")},
+	{name: "int8_select_columns_of_b", params: valueTypes("iiiiii")},
+}
+
+func valueTypes(s string) []api.ValueType {
+	types := make([]api.ValueType, len(s))
+	for i, c := range s {
+		if c == 'f' {
+			types[i] = api.ValueTypeF32
+		} else {
+			types[i] = api.ValueTypeI32
+		}
+	}
+	return types
+}
+
+// gemmCallerWASM builds a module which imports wasm_gemm functions and exports functions with the same
+// names and parameters calling them, so that the imports can be called like Marian does. It also
+// exports memory of the given number of pages.
+func gemmCallerWASM(pages uint32) []byte {
+	leb := func(b []byte, v uint32) []byte {
+		for {
+			c := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 {
+				return append(b, c)
+			}
+			b = append(b, c|0x80)
+		}
+	}
+	name := func(b []byte, s string) []byte {
+		return append(leb(b, uint32(len(s))), s...)
+	}
+	section := func(b []byte, id byte, content []byte) []byte {
+		return append(leb(append(b, id), uint32(len(content))), content...)
+	}
+	n := uint32(len(gemmImports))
+
+	var types, imports, functions, exports, code []byte
+	types, imports, functions, exports, code = leb(types, n), leb(imports, n), leb(functions, n), leb(exports, n+1), leb(code, n)
+	for i, imp := range gemmImports {
+		types = append(leb(append(types, 0x60), uint32(len(imp.params))), imp.params...)
+		types = append(types, 0) // no results
+		imports = leb(append(name(name(imports, "wasm_gemm"), imp.name), 0x00), uint32(i))
+		functions = leb(functions, uint32(i))
+		exports = leb(append(name(exports, imp.name), 0x00), n+uint32(i))
+
+		body := []byte{0} // no locals
+		for p := range imp.params {
+			body = leb(append(body, 0x20), uint32(p)) // local.get
+		}
+		body = append(leb(append(body, 0x10), uint32(i)), 0x0b) // call, end
+		code = append(leb(code, uint32(len(body))), body...)
+	}
+	exports = append(name(exports, "memory"), 0x02, 0)
+	memories := leb([]byte{1, 0x00}, pages)
+
+	module := []byte{0x00, 'a', 's', 'm', 1, 0, 0, 0}
+	module = section(module, 1, types)
+	module = section(module, 2, imports)
+	module = section(module, 3, functions)
+	module = section(module, 5, memories)
+	module = section(module, 7, exports)
+	return section(module, 10, code)
+}
+
+// instantiateGemmCaller instantiates module built by gemmCallerWASM with wasm_gemm calling the backend.
+func instantiateGemmCaller(t *testing.T, ctx context.Context, backend GemmBackend) api.Module {
+	t.Helper()
+	wasmRuntime := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { wasmRuntime.Close(ctx) })
+
+	gemmModule := wasmRuntime.NewHostModuleBuilder("wasm_gemm")
+	exportGemm(gemmModule, backend)
+	if _, err := gemmModule.Instantiate(ctx); err != nil {
+		t.Fatalf("failed to instantiate wasm_gemm: %v", err)
+	}
+	mod, err := wasmRuntime.Instantiate(ctx, gemmCallerWASM(64))
+	if err != nil {
+		t.Fatalf("failed to instantiate gemm caller: %v", err)
+	}
+	return mod
+}
+
+// recordingGemm records calls with their parameters and returns err from them.
+type recordingGemm struct {
+	calls []string
+	err   error
+}
+
+func (r *recordingGemm) record(method string, params ...any) error {
+	r.calls = append(r.calls, fmt.Sprint(method, params))
+	return r.err
+}
+
+func (r *recordingGemm) Int8PrepareA(
+	ctx context.Context, mod api.Module,
+	inputA uint32, scale, zeroPoint float32, rowsA, width uint32, output uint32,
+) error {
+	return r.record("Int8PrepareA", inputA, scale, zeroPoint, rowsA, width, output)
+}
+
+func (r *recordingGemm) Int8PrepareB(
+	ctx context.Context, mod api.Module,
+	inputB uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+) error {
+	return r.record("Int8PrepareB", inputB, scale, zeroPoint, width, colsB, output)
+}
+
+func (r *recordingGemm) Int8PrepareBFromTransposed(
+	ctx context.Context, mod api.Module,
+	inputBTransposed uint32, scale, zeroPoint float32, width, colsB uint32, output uint32,
+) error {
+	return r.record("Int8PrepareBFromTransposed", inputBTransposed, scale, zeroPoint, width, colsB, output)
+}
+
+func (r *recordingGemm) Int8PrepareBFromQuantizedTransposed(
+	ctx context.Context, mod api.Module,
+	inputBQuantTransposed uint32, width, colsB uint32, output uint32,
+) error {
+	return r.record("Int8PrepareBFromQuantizedTransposed", inputBQuantTransposed, width, colsB, output)
+}
+
+func (r *recordingGemm) Int8PrepareBias(
+	ctx context.Context, mod api.Module,
+	inputBPrepared uint32, scaleA, zeroPointA, scaleB, zeroPointB float32, width, colsB uint32,
+	inputBias uint32, output uint32,
+) error {
+	return r.record("Int8PrepareBias", inputBPrepared, scaleA, zeroPointA, scaleB, zeroPointB, width, colsB,
+		inputBias, output)
+}
+
+func (r *recordingGemm) Int8MultiplyAndAddBias(
+	ctx context.Context, mod api.Module,
+	inputAPrepared uint32, scaleA, zeroPointA float32,
+	inputBPrepared uint32, scaleB, zeroPointB float32,
+	inputBiasPrepared uint32, unquantMultiplier float32,
+	rowsA, width, colsB uint32, output uint32,
+) error {
+	return r.record("Int8MultiplyAndAddBias", inputAPrepared, scaleA, zeroPointA, inputBPrepared, scaleB, zeroPointB,
+		inputBiasPrepared, unquantMultiplier, rowsA, width, colsB, output)
+}
+
+func (r *recordingGemm) Int8SelectColumnsOfB(
+	ctx context.Context, mod api.Module,
+	inputBPrepared uint32, width, colsB uint32, cols uint32, numCols uint32, output uint32,
+) error {
+	return r.record("Int8SelectColumnsOfB", inputBPrepared, width, colsB, cols, numCols, output)
+}
+
+func TestExportGemm(t *testing.T) {
+	ctx := context.Background()
+	imported := &recordingGemm{}
+	mod := instantiateGemmCaller(t, ctx, imported)
+
+	// every parameter gets a distinct value, so misplaced parameters are detected
+	for _, imp := range gemmImports {
+		params := make([]uint64, len(imp.params))
+		for i, typ := range imp.params {
+			if typ == api.ValueTypeF32 {
+				params[i] = api.EncodeF32(float32(i) + 0.5)
+			} else {
+				params[i] = api.EncodeU32(uint32(i))
+			}
+		}
+		if _, err := mod.ExportedFunction(imp.name).Call(ctx, params...); err != nil {
+			t.Fatalf("failed to call %s: %v", imp.name, err)
+		}
+	}
+
+	direct := &recordingGemm{}
+	u := func(i int) uint32 { return uint32(i) }
+	f := func(i int) float32 { return float32(i) + 0.5 }
+	_ = direct.Int8PrepareA(ctx, mod, u(0), f(1), f(2), u(3), u(4), u(5))
+	_ = direct.Int8PrepareB(ctx, mod, u(0), f(1), f(2), u(3), u(4), u(5))
+	_ = direct.Int8PrepareBFromTransposed(ctx, mod, u(0), f(1), f(2), u(3), u(4), u(5))
+	_ = direct.Int8PrepareBFromQuantizedTransposed(ctx, mod, u(0), u(1), u(2), u(3))
+	_ = direct.Int8PrepareBias(ctx, mod, u(0), f(1), f(2), f(3), f(4), u(5), u(6), u(7), u(8))
+	_ = direct.Int8MultiplyAndAddBias(ctx, mod, u(0), f(1), f(2), u(3), f(4), f(5), u(6), f(7), u(8), u(9), u(10), u(11))
+	_ = direct.Int8SelectColumnsOfB(ctx, mod, u(0), u(1), u(2), u(3), u(4), u(5))
+
+	if !slices.Equal(imported.calls, direct.calls) {
+		t.Errorf("imported calls\n%v\ndiffer from direct calls\n%v", imported.calls, direct.calls)
+	}
+}
+
+func TestExportGemm_Error(t *testing.T) {
+	ctx := context.Background()
+	backendErr := errors.New("backend failed")
+	mod := instantiateGemmCaller(t, ctx, &recordingGemm{err: backendErr})
+
+	for _, imp := range gemmImports {
+		params := make([]uint64, len(imp.params))
+		_, err := mod.ExportedFunction(imp.name).Call(ctx, params...)
+		if !errors.Is(err, backendErr) {
+			t.Fatalf("expected %s error to wrap backend error, got %v", imp.name, err)
+		}
+		var hostErr *HostFuncError
+		if !errors.As(err, &hostErr) || hostErr.Module != "wasm_gemm" || hostErr.Func != imp.name {
+			t.Errorf("expected %s error to be HostFuncError of the function, got %#v", imp.name, hostErr)
+		}
+	}
+}
+
+// gemmMemory allocates and accesses buffers in the module memory for conformance tests.
+type gemmMemory struct {
+	t     *testing.T
+	mod   api.Module
+	alloc func(size uint32) uint32
+}
+
+func (m gemmMemory) floats(values []float32) uint32 {
+	ptr := m.alloc(uint32(len(values) * 4))
+	for i, v := range values {
+		m.mod.Memory().WriteFloat32Le(ptr+uint32(i*4), v)
+	}
+	return ptr
+}
+
+func (m gemmMemory) uint32s(values []uint32) uint32 {
+	ptr := m.alloc(uint32(len(values) * 4))
+	for i, v := range values {
+		m.mod.Memory().WriteUint32Le(ptr+uint32(i*4), v)
+	}
+	return ptr
+}
+
+func (m gemmMemory) int8s(values []int8) uint32 {
+	ptr := m.alloc(uint32(len(values)))
+	for i, v := range values {
+		m.mod.Memory().WriteByte(ptr+uint32(i), byte(v))
+	}
+	return ptr
+}
+
+func (m gemmMemory) readFloats(ptr uint32, n int) []float32 {
+	values := make([]float32, n)
+	for i := range values {
+		values[i], _ = m.mod.Memory().ReadFloat32Le(ptr + uint32(i*4))
+	}
+	return values
+}
+
+type gemmCase struct {
+	rows, width, cols int
+	a, b, bias        []float32
+	selected          []uint32
+	unquantMultiplier float32
+}
+
+func randomGemmCases() []gemmCase {
+	rng := rand.New(rand.NewPCG(5, 6))
+	random := func(n int) []float32 {
+		values := make([]float32, n)
+		for i := range values {
+			values[i] = rng.Float32()*2 - 1
+		}
+		return values
+	}
+
+	var cases []gemmCase
+	// intgemm requires width to be a multiple of 64 and columns to be a multiple of 8
+	for _, shape := range [][3]int{{1, 64, 8}, {5, 64, 16}, {8, 128, 8}, {3, 256, 64}} {
+		rows, width, cols := shape[0], shape[1], shape[2]
+		selected := make([]uint32, 8)
+		for i := range selected {
+			selected[i] = uint32(rng.IntN(cols))
+		}
+		cases = append(cases, gemmCase{
+			rows: rows, width: width, cols: cols,
+			a: random(rows * width), b: random(width * cols), bias: random(cols),
+			selected:          selected,
+			unquantMultiplier: []float32{1, 0.5}[rng.IntN(2)],
+		})
+	}
+	return cases
+}
+
+// runGemm runs the case through the backend and returns outputs of multiplications with B
+// prepared in every way, each followed by a multiplication with selected columns of B.
+func runGemm(t *testing.T, ctx context.Context, backend GemmBackend, m gemmMemory, c gemmCase) [][]float32 {
+	t.Helper()
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, width, cols, numSelected := uint32(c.rows), uint32(c.width), uint32(c.cols), uint32(len(c.selected))
+	// B range is halved so that maddubs pairs do not saturate, as it is with real models
+	scaleA, scaleB := float32(127), float32(63)
+
+	transposedB := make([]float32, len(c.b))
+	quantizedTransposedB := make([]int8, len(c.b))
+	for k := 0; k < c.width; k++ {
+		for col := 0; col < c.cols; col++ {
+			v := c.b[k*c.cols+col]
+			transposedB[col*c.width+k] = v
+			quantizedTransposedB[col*c.width+k] = int8(max(min(math.RoundToEven(float64(v*scaleB)), 127), -127))
+		}
+	}
+
+	preparedA := m.alloc(rows * width)
+	check(backend.Int8PrepareA(ctx, m.mod, m.floats(c.a), scaleA, 0, rows, width, preparedA))
+
+	preparedBs := make([]uint32, 3)
+	for i := range preparedBs {
+		preparedBs[i] = m.alloc(width * cols)
+	}
+	check(backend.Int8PrepareB(ctx, m.mod, m.floats(c.b), scaleB, 0, width, cols, preparedBs[0]))
+	check(backend.Int8PrepareBFromTransposed(ctx, m.mod, m.floats(transposedB), scaleB, 0, width, cols, preparedBs[1]))
+	check(backend.Int8PrepareBFromQuantizedTransposed(ctx, m.mod, m.int8s(quantizedTransposedB), width, cols,
+		preparedBs[2]))
+
+	selectedBias := make([]float32, len(c.selected))
+	for i, col := range c.selected {
+		selectedBias[i] = c.bias[col]
+	}
+	bias, selected := m.floats(c.bias), m.uint32s(c.selected)
+
+	var outputs [][]float32
+	multiply := func(preparedB, cols, bias uint32) {
+		preparedBias := m.alloc(cols * 4)
+		check(backend.Int8PrepareBias(ctx, m.mod, preparedB, scaleA, 0, scaleB, 0, width, cols, bias, preparedBias))
+		output := m.alloc(rows * cols * 4)
+		check(backend.Int8MultiplyAndAddBias(ctx, m.mod, preparedA, scaleA, 0, preparedB, scaleB, 0,
+			preparedBias, c.unquantMultiplier, rows, width, cols, output))
+		outputs = append(outputs, m.readFloats(output, int(rows*cols)))
+	}
+	for _, preparedB := range preparedBs {
+		multiply(preparedB, cols, bias)
+
+		selectedB := m.alloc(width * numSelected)
+		check(backend.Int8SelectColumnsOfB(ctx, m.mod, preparedB, width, cols, selected, numSelected, selectedB))
+		multiply(selectedB, numSelected, m.floats(selectedBias))
+	}
+	return outputs
+}
+
+func gemmCallerMemory(t *testing.T, mod api.Module) gemmMemory {
+	next := uint32(1024)
+	return gemmMemory{t: t, mod: mod, alloc: func(size uint32) uint32 {
+		ptr := next
+		next += (size + 63) &^ 63
+		if next > mod.Memory().Size() {
+			t.Fatalf("gemm caller memory is exhausted")
+		}
+		return ptr
+	}}
+}
+
+func bergamotMemory(t *testing.T, ctx context.Context, mod api.Module) gemmMemory {
+	malloc := mod.ExportedFunction("malloc")
+	if malloc == nil {
+		t.Fatal("malloc is not exported by Bergamot module")
+	}
+	return gemmMemory{t: t, mod: mod, alloc: func(size uint32) uint32 {
+		res, err := malloc.Call(ctx, api.EncodeU32(size))
+		if err != nil || res[0] == 0 {
+			t.Fatalf("failed to allocate %d bytes: %v", size, err)
+		}
+		return api.DecodeU32(res[0])
+	}}
+}
+
+func TestGemmBackends(t *testing.T) {
+	ctx := context.Background()
+	cases := randomGemmCases()
+
+	expected := make([][][]float32, len(cases))
+	reference := instantiateGemmCaller(t, ctx, ReferenceGemm())
+	for i, c := range cases {
+		expected[i] = runGemm(t, ctx, ReferenceGemm(), gemmCallerMemory(t, reference), c)
+	}
+
+	backends := []struct {
+		name    string
+		backend GemmBackend
+		memory  func(t *testing.T) gemmMemory
+	}{
+		{
+			name:    "native",
+			backend: NativeGemm(),
+			memory: func(t *testing.T) gemmMemory {
+				return gemmCallerMemory(t, instantiateGemmCaller(t, ctx, NativeGemm()))
+			},
+		},
+		{
+			name:    "fallback",
+			backend: FallbackGemm(),
+			memory: func(t *testing.T) gemmMemory {
+				if !bytes.HasPrefix(BergamotWASM(), []byte("\x00asm")) {
+					t.Skip("Bergamot WASM module is missing, it is fetched with git lfs pull")
+				}
+				ctx := ctx
+				wasmRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+					WithCoreFeatures(api.CoreFeaturesV2|experimental.CoreFeaturesThreads))
+				t.Cleanup(func() { wasmRuntime.Close(ctx) })
+				embindEngine := embind.CreateEngine(embind.NewConfig())
+				ctx = embindEngine.Attach(ctx)
+				mod, err := CompileBergamot(ctx, wasmRuntime, embindEngine, CompileConfig{
+					Stderr: io.Discard,
+					Stdout: io.Discard,
+				})
+				if err != nil {
+					t.Fatalf("failed to compile Bergamot: %v", err)
+				}
+				return bergamotMemory(t, ctx, mod)
+			},
+		},
+	}
+
+	for _, tt := range backends {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.memory(t)
+			for i, c := range cases {
+				outputs := runGemm(t, ctx, tt.backend, m, c)
+				for j := range outputs {
+					for k, v := range outputs[j] {
+						want := expected[i][j][k]
+						if math.Abs(float64(v-want)) > 1e-4*max(1, math.Abs(float64(want))) {
+							t.Fatalf("case %d (%dx%dx%d), output %d, element %d is %v, expected %v",
+								i, c.rows, c.width, c.cols, j, k, v, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+)
+
+// globalExportPrefix starts names of exports added by ExportMutableGlobals.
+const globalExportPrefix = "gobergamot.global."
+
+// ExportMutableGlobals returns a copy of the module exporting all mutable globals it defines,
+// so the host can save and restore them along with the linear memory, and names of their exports.
+// Emscripten exports only some of them, e.g. the stack pointer, and keeps the others internal.
+// Globals exported by the module keep their names, others are exported as "gobergamot.global.<index>".
+func ExportMutableGlobals(module []byte) ([]byte, []string, error) {
+	const headerSize = 8
+	if len(module) < headerSize || !bytes.Equal(module[:4], []byte("\x00asm")) {
+		return nil, nil, errMalformedModule
+	}
+
+	var (
+		importedGlobals uint32
+		mutable         []uint32
+		// exported are names of exported globals by their indexes
+		exported = make(map[uint32]string)
+		// exportStart and exportEnd are bounds of the export section in the module, if it has one
+		exportStart, exportEnd int
+		exportContent          []byte
+		// globalEnd is the end of the global section, the export section follows it
+		globalEnd int
+	)
+	r := module[headerSize:]
+	for len(r) != 0 {
+		start := len(module) - len(r)
+		id := r[0]
+		size, n := binary.Uvarint(r[1:])
+		if n <= 0 || uint64(len(r)-1-n) < size {
+			return nil, nil, errMalformedModule
+		}
+		content := r[1+n : 1+n+int(size)]
+		r = r[1+n+int(size):]
+		end := len(module) - len(r)
+
+		var err error
+		switch id {
+		case 2:
+			importedGlobals, err = countImports(content, externGlobal)
+		case 6:
+			mutable, err = mutableGlobals(content)
+			globalEnd = end
+		case 7:
+			exportStart, exportEnd, exportContent = start, end, content
+			err = exportedGlobals(content, exported)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(mutable) == 0 {
+		return module, nil, nil
+	}
+
+	var (
+		names   []string
+		added   []byte
+		nAdded  uint64
+		entries []byte
+		count   uint64
+	)
+	for _, i := range mutable {
+		index := importedGlobals + i
+		if name, ok := exported[index]; ok {
+			names = append(names, name)
+			continue
+		}
+		name := globalExportPrefix + strconv.FormatUint(uint64(index), 10)
+		names = append(names, name)
+		added = binary.AppendUvarint(added, uint64(len(name)))
+		added = append(added, name...)
+		added = binary.AppendUvarint(append(added, externGlobal), uint64(index))
+		nAdded++
+	}
+	if nAdded == 0 {
+		return module, names, nil
+	}
+	if exportContent != nil {
+		var n int
+		count, n = binary.Uvarint(exportContent)
+		entries = exportContent[n:]
+	} else {
+		// sections with known ids are ordered, so a new export section goes right after the global one
+		exportStart, exportEnd = globalEnd, globalEnd
+	}
+
+	content := binary.AppendUvarint(nil, count+nAdded)
+	content = append(append(content, entries...), added...)
+	section := binary.AppendUvarint([]byte{7}, uint64(len(content)))
+	section = append(section, content...)
+
+	result := make([]byte, 0, len(module)-(exportEnd-exportStart)+len(section))
+	result = append(result, module[:exportStart]...)
+	result = append(result, section...)
+	result = append(result, module[exportEnd:]...)
+	return result, names, nil
+}
+
+// mutableGlobals returns indexes of mutable globals in the global section,
+// which do not count imported globals.
+func mutableGlobals(content []byte) ([]uint32, error) {
+	count, n := binary.Uvarint(content)
+	if n <= 0 {
+		return nil, errMalformedModule
+	}
+	r := content[n:]
+	var mutable []uint32
+	for i := range uint32(count) {
+		// value type and mutability
+		if len(r) < 2 {
+			return nil, errMalformedModule
+		}
+		if r[1] == 1 {
+			mutable = append(mutable, i)
+		}
+		var ok bool
+		if r, ok = skipConstExpr(r[2:]); !ok {
+			return nil, errMalformedModule
+		}
+	}
+	return mutable, nil
+}
+
+// skipConstExpr skips a constant expression of a global initializer up to its end instruction.
+func skipConstExpr(r []byte) ([]byte, bool) {
+	skipLEB := func() bool {
+		for i := 0; i < len(r) && i < 10; i++ {
+			if r[i]&0x80 == 0 {
+				r = r[i+1:]
+				return true
+			}
+		}
+		return false
+	}
+	skipBytes := func(n int) bool {
+		if len(r) < n {
+			return false
+		}
+		r = r[n:]
+		return true
+	}
+	for len(r) != 0 {
+		op := r[0]
+		r = r[1:]
+		ok := true
+		switch op {
+		case 0x0b: // end
+			return r, true
+		case 0x41, 0x42, 0x23, 0xd2: // i32.const, i64.const, global.get, ref.func
+			ok = skipLEB()
+		case 0x43: // f32.const
+			ok = skipBytes(4)
+		case 0x44: // f64.const
+			ok = skipBytes(8)
+		case 0xd0: // ref.null: reference type
+			ok = skipBytes(1)
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e: // extended constant arithmetic
+		case 0xfd: // v128.const
+			ok = skipLEB() && skipBytes(16)
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// exportedGlobals adds names of globals exported in the export section to exported by their indexes.
+func exportedGlobals(content []byte, exported map[uint32]string) error {
+	count, n := binary.Uvarint(content)
+	if n <= 0 {
+		return errMalformedModule
+	}
+	r := content[n:]
+	for range count {
+		size, n := binary.Uvarint(r)
+		if n <= 0 || uint64(len(r)-n) < size+1 {
+			return errMalformedModule
+		}
+		name := string(r[n : n+int(size)])
+		kind := r[n+int(size)]
+		r = r[n+int(size)+1:]
+		index, n := binary.Uvarint(r)
+		if n <= 0 {
+			return errMalformedModule
+		}
+		r = r[n:]
+		if kind == externGlobal {
+			if _, ok := exported[uint32(index)]; !ok {
+				exported[uint32(index)] = name
+			}
+		}
+	}
+	return nil
+}
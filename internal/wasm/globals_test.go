@@ -0,0 +1,97 @@
+package wasm
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// globalsWASM builds a module importing an immutable i32 global env.g and defining
+// a mutable i32, an immutable i32 and a mutable i64 global. The first one is exported
+// as __stack_pointer if exportStack is set.
+func globalsWASM(exportStack bool) []byte {
+	section := func(b []byte, id byte, content []byte) []byte {
+		return append(binary.AppendUvarint(append(b, id), uint64(len(content))), content...)
+	}
+	module := []byte{0x00, 'a', 's', 'm', 1, 0, 0, 0}
+	module = section(module, 2, []byte{1, 3, 'e', 'n', 'v', 1, 'g', 0x03, 0x7f, 0x00})
+	module = section(module, 6, []byte{
+		3,
+		0x7f, 1, 0x41, 0x80, 0x80, 0x04, 0x0b, // mutable i32: i32.const 65536
+		0x7f, 0, 0x23, 0x00, 0x0b, // immutable i32: global.get 0
+		0x7e, 1, 0x42, 0x7f, 0x0b, // mutable i64: i64.const -1
+	})
+	if exportStack {
+		exports := []byte{1, byte(len("__stack_pointer"))}
+		exports = append(append(exports, "__stack_pointer"...), 0x03, 1)
+		module = section(module, 7, exports)
+	}
+	// custom section after the global section
+	return section(module, 0, []byte{4, 'n', 'o', 't', 'e', 1})
+}
+
+func TestExportMutableGlobals(t *testing.T) {
+	ctx := context.Background()
+	wasmRuntime := wazero.NewRuntime(ctx)
+	defer wasmRuntime.Close(ctx)
+
+	env := []byte{0x00, 'a', 's', 'm', 1, 0, 0, 0}
+	env = append(env, 6, 6, 1, 0x7f, 0x00, 0x41, 0x05, 0x0b)
+	env = append(env, 7, 5, 1, 1, 'g', 0x03, 0)
+	if _, err := wasmRuntime.InstantiateWithConfig(ctx, env, wazero.NewModuleConfig().WithName("env")); err != nil {
+		t.Fatalf("failed to instantiate env module: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		exportStack bool
+		want        []string
+	}{
+		{name: "with exports", exportStack: true, want: []string{"__stack_pointer", "gobergamot.global.3"}},
+		{name: "without exports", want: []string{"gobergamot.global.1", "gobergamot.global.3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			module, names, err := ExportMutableGlobals(globalsWASM(tc.exportStack))
+			if err != nil {
+				t.Fatalf("failed to export globals: %v", err)
+			}
+			if !slices.Equal(names, tc.want) {
+				t.Fatalf("expected exports %v, got %v", tc.want, names)
+			}
+
+			mod, err := wasmRuntime.InstantiateWithConfig(ctx, module, wazero.NewModuleConfig().WithName(""))
+			if err != nil {
+				t.Fatalf("failed to instantiate module: %v", err)
+			}
+			defer mod.Close(ctx)
+			stack, ok := mod.ExportedGlobal(names[0]).(api.MutableGlobal)
+			if !ok {
+				t.Fatalf("expected %s to be a mutable global", names[0])
+			}
+			if got := api.DecodeU32(stack.Get()); got != 65536 {
+				t.Errorf("expected %s to be 65536, got %d", names[0], got)
+			}
+			stack.Set(api.EncodeU32(1024))
+			if got := api.DecodeU32(mod.ExportedGlobal(names[0]).Get()); got != 1024 {
+				t.Errorf("expected %s to be set to 1024, got %d", names[0], got)
+			}
+			if got := int64(mod.ExportedGlobal(names[1]).Get()); got != -1 {
+				t.Errorf("expected %s to be -1, got %d", names[1], got)
+			}
+		})
+	}
+}
+
+func TestExportMutableGlobals_Malformed(t *testing.T) {
+	module := globalsWASM(true)
+	if _, _, err := ExportMutableGlobals(module[:len(module)-3]); err == nil {
+		t.Error("expected error for truncated module")
+	}
+	if _, _, err := ExportMutableGlobals([]byte("not a module")); err == nil {
+		t.Error("expected error for not a module")
+	}
+}
@@ -0,0 +1,68 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// RemoveMemoryMax returns a copy of the module which memory has no maximum size, and the removed
+// maximum in pages. The runtime refuses to grow memory over the maximum without asking its memory
+// allocator, so the host removes it to enforce the limit in the allocator and learn about refused grows.
+// Shared memory must have a maximum, so the module is returned as is then.
+func RemoveMemoryMax(module []byte) (_ []byte, maxPages uint32, hasMax bool, _ error) {
+	const headerSize = 8
+	if len(module) < headerSize || !bytes.Equal(module[:4], []byte("\x00asm")) {
+		return nil, 0, false, errMalformedModule
+	}
+
+	r := module[headerSize:]
+	for len(r) != 0 {
+		start := len(module) - len(r)
+		id := r[0]
+		size, n := binary.Uvarint(r[1:])
+		if n <= 0 || uint64(len(r)-1-n) < size {
+			return nil, 0, false, errMalformedModule
+		}
+		content := r[1+n : 1+n+int(size)]
+		r = r[1+n+int(size):]
+		if id != 5 {
+			continue
+		}
+
+		count, n := binary.Uvarint(content)
+		if n <= 0 || count != 1 || len(content) == n {
+			return module, 0, false, nil
+		}
+		flags := content[n]
+		limits := content[n+1:]
+		min, n := binary.Uvarint(limits)
+		if n <= 0 {
+			return nil, 0, false, errMalformedModule
+		}
+		if flags&1 == 0 {
+			return module, 0, false, nil
+		}
+		max, m := binary.Uvarint(limits[n:])
+		if m <= 0 {
+			return nil, 0, false, errMalformedModule
+		}
+		// shared and 64-bit memories are left as is
+		if flags != 1 {
+			return module, uint32(max), true, nil
+		}
+
+		memory := []byte{1, 0}
+		memory = binary.AppendUvarint(memory, min)
+		memory = append(memory, limits[n+m:]...)
+		section := binary.AppendUvarint([]byte{5}, uint64(len(memory)))
+		section = append(section, memory...)
+
+		end := len(module) - len(r)
+		result := make([]byte, 0, len(module)-(end-start)+len(section))
+		result = append(result, module[:start]...)
+		result = append(result, section...)
+		result = append(result, module[end:]...)
+		return result, uint32(max), true, nil
+	}
+	return module, 0, false, nil
+}
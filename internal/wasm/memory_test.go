@@ -0,0 +1,48 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func TestRemoveMemoryMax(t *testing.T) {
+	ctx := context.Background()
+	wasmRuntime := wazero.NewRuntime(ctx)
+	defer wasmRuntime.Close(ctx)
+
+	unlimited := abiWASM(nil, []testFunc{{name: "f"}}, true)
+	// memory of 1 page at least and 300 pages at most, which has a two-byte encoding
+	limited := bytes.Replace(unlimited, []byte{5, 3, 1, 0x00, 1}, []byte{5, 5, 1, 0x01, 1, 0xac, 0x02}, 1)
+
+	module, maxPages, hasMax, err := RemoveMemoryMax(limited)
+	if err != nil {
+		t.Fatalf("failed to remove memory max: %v", err)
+	}
+	if !hasMax || maxPages != 300 {
+		t.Errorf("expected removed max of 300 pages, got %d (%t)", maxPages, hasMax)
+	}
+	compiled, err := wasmRuntime.CompileModule(ctx, module)
+	if err != nil {
+		t.Fatalf("failed to compile module: %v", err)
+	}
+	memory := compiled.ExportedMemories()["memory"]
+	if _, encoded := memory.Max(); encoded || memory.Min() != 1 {
+		t.Errorf("expected memory of 1 page without max, got min %d and max encoded %t", memory.Min(), encoded)
+	}
+
+	module, _, hasMax, err = RemoveMemoryMax(unlimited)
+	if err != nil {
+		t.Fatalf("failed to remove memory max: %v", err)
+	}
+	if hasMax || !bytes.Equal(module, unlimited) {
+		t.Error("expected module without memory max to be returned as is")
+	}
+
+	truncated := limited[:bytes.Index(limited, []byte{5, 5, 1, 0x01})+4]
+	if _, _, _, err := RemoveMemoryMax(truncated); err == nil {
+		t.Error("expected error for truncated module")
+	}
+}
@@ -15,6 +15,9 @@ import (
 type CompileConfig struct {
 	// Stderr and Stdout enable redirection of any logs. If left nil they point at os.Stderr and os.Stdout. Turn off by setting them to io.Discard
 	Stderr, Stdout io.Writer
+
+	// GemmBackend implements int8 matrix multiplication imported by Marian. If nil, FallbackGemm is used.
+	GemmBackend GemmBackend
 }
 
 func BergamotWASM() []byte {
@@ -31,12 +34,32 @@ func CompileBergamot(
 	if err != nil {
 		return nil, fmt.Errorf("CompileModule: %w", err)
 	}
+	if err := CheckExports(bergamotCompiledModule, cfg); err != nil {
+		return nil, err
+	}
 
-	if err := BuildImports(ctx, wasmRuntime, embindEng, bergamotCompiledModule); err != nil {
+	if err := BuildImports(ctx, wasmRuntime, embindEng, bergamotCompiledModule, cfg); err != nil {
 		return nil, fmt.Errorf("BuildImports: %w", err)
 	}
+	if err := CheckImports(wasmRuntime, bergamotCompiledModule); err != nil {
+		return nil, err
+	}
 
+	return InstantiateBergamot(ctx, wasmRuntime, embindEng, bergamotCompiledModule, cfg)
+}
+
+// InstantiateBergamot creates a new instance of the compiled Bergamot module, which imports
+// must be built by BuildImports. Instances are anonymous, so a runtime can have many of them.
+// ABIError is returned if the module does not register embind classes the library uses.
+func InstantiateBergamot(
+	ctx context.Context,
+	wasmRuntime wazero.Runtime,
+	embindEng embind.Engine,
+	bergamotCompiledModule wazero.CompiledModule,
+	cfg CompileConfig,
+) (api.Module, error) {
 	moduleConfig := wazero.NewModuleConfig().
+		WithName("").
 		WithStderr(cfg.Stderr).
 		WithStdout(cfg.Stdout).
 		WithStartFunctions("_initialize")
@@ -46,5 +69,8 @@ func CompileBergamot(
 		return nil, fmt.Errorf("InstantiateModule: %w", err)
 	}
 
+	if err := checkClasses(embindEng); err != nil {
+		return bergamotModule, err
+	}
 	return bergamotModule, gen.Attach(embindEng)
 }
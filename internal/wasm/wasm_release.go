@@ -8,3 +8,9 @@ import (
 
 //go:embed bergamot-translator-worker.wasm
 var bergamotTranslatorWorkerWASM []byte
+
+// DebugInfo reports if the embedded module has DWARF sections to resolve C++ source locations in stack traces.
+const DebugInfo = false
+
+// DebugBuild reports if the library is built with gobergamot_debug tag, which enables checks too costly for release builds.
+const DebugBuild = false
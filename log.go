@@ -0,0 +1,154 @@
+package gobergamot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// maxLogLineSize limits a line buffered by MarianLogWriter, longer lines are logged in parts.
+const maxLogLineSize = 64 << 10
+
+// loggerOrDiscard returns the logger or one discarding records if it is nil.
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return logger
+}
+
+// NewMarianLogWriter returns io.Writer converting Marian log lines written by Bergamot module
+// into logger records. Lines look like
+//
+//	[2024-05-01 10:00:00] [memory] Reserving 128 MB, device cpu0
+//	[2024-05-01 10:00:00] [warn] Unknown option
+//	[2024-05-01 10:00:00] Error: Requested shortlist does not exist
+//
+// The level of a record is taken from level tag or "Error:" and "Warning:" prefix
+// of the message and is defaultLevel otherwise. Other tags, like "memory" above, are put into
+// "component" attribute. Marian timestamps are dropped in favor of the record time.
+//
+// Config.Logger makes Translator use the writer for Stderr and Stdout if they are nil.
+func NewMarianLogWriter(logger *slog.Logger, defaultLevel slog.Level) io.Writer {
+	return &marianLogWriter{
+		logger:       logger.With(slog.String("logger", "marian")),
+		defaultLevel: defaultLevel,
+	}
+}
+
+type marianLogWriter struct {
+	logger       *slog.Logger
+	defaultLevel slog.Level
+
+	// mu guards buf, since both Stderr and Stdout may refer to the writer
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *marianLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	rest := w.buf
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(string(rest[:i]))
+		rest = rest[i+1:]
+	}
+	if len(rest) >= maxLogLineSize {
+		w.log(string(rest))
+		rest = nil
+	}
+	// remaining part of the line is moved to the buffer start to reuse its capacity
+	w.buf = append(w.buf[:0], rest...)
+	return len(p), nil
+}
+
+func (w *marianLogWriter) log(line string) {
+	line = strings.TrimRight(line, "\r ")
+	if line == "" {
+		return
+	}
+	level, component, msg := parseMarianLogLine(line, w.defaultLevel)
+	if !w.logger.Enabled(context.Background(), level) {
+		return
+	}
+	if component != "" {
+		w.logger.Log(context.Background(), level, msg, slog.String("component", component))
+		return
+	}
+	w.logger.Log(context.Background(), level, msg)
+}
+
+var marianLogLevels = map[string]slog.Level{
+	"trace":    slog.LevelDebug - 4,
+	"debug":    slog.LevelDebug,
+	"info":     slog.LevelInfo,
+	"warn":     slog.LevelWarn,
+	"warning":  slog.LevelWarn,
+	"error":    slog.LevelError,
+	"critical": slog.LevelError,
+}
+
+var marianMessageLevels = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{prefix: "Error: ", level: slog.LevelError},
+	{prefix: "Warning: ", level: slog.LevelWarn},
+}
+
+// parseMarianLogLine splits the line into level, component and message.
+func parseMarianLogLine(line string, defaultLevel slog.Level) (level slog.Level, component, msg string) {
+	level = defaultLevel
+	levelSet := false
+	msg = line
+	for first := true; strings.HasPrefix(msg, "["); first = false {
+		end := strings.IndexByte(msg, ']')
+		if end < 0 {
+			break
+		}
+		tag := msg[1:end]
+		switch tagLevel, ok := marianLogLevels[strings.ToLower(tag)]; {
+		case ok:
+			level, levelSet = tagLevel, true
+		case first && isMarianTimestamp(tag):
+		case component == "":
+			component = tag
+		default:
+			// not a tag, but a part of the message
+			return level, component, msg
+		}
+		msg = strings.TrimLeft(msg[end+1:], " ")
+	}
+	for _, ml := range marianMessageLevels {
+		if strings.HasPrefix(msg, ml.prefix) {
+			if !levelSet {
+				level = ml.level
+			}
+			msg = msg[len(ml.prefix):]
+			break
+		}
+	}
+	return level, component, msg
+}
+
+// isMarianTimestamp reports if the tag looks like "2024-05-01 10:00:00".
+func isMarianTimestamp(tag string) bool {
+	if len(tag) < len("2006-01-02") {
+		return false
+	}
+	for _, r := range tag {
+		if (r < '0' || r > '9') && !strings.ContainsRune("-: .", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package gobergamot
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// ErrOutOfMemory is returned if loading a model or translating requires more WASM memory
+// than Config.MaxMemoryBytes or the limit encoded in the module allow.
+var ErrOutOfMemory = errors.New("out of WASM memory")
+
+// maxMemoryBytes is the largest memory addressable by 32-bit WASM module
+const maxMemoryBytes = 65536 * uint64(wasmMemoryPageSize)
+
+// memoryLimitPages converts Config.MaxMemoryBytes into a number of WASM pages.
+func memoryLimitPages(maxBytes uint64) uint32 {
+	return uint32(maxBytes / uint64(wasmMemoryPageSize))
+}
+
+// memoryAllocator backs linear memory of a module instance and enforces the memory limit.
+//
+// The limit is not set with wazero.RuntimeConfig WithMemoryLimitPages, since wazero refuses grows
+// over the memory maximum before calling anything the host provides, and the module then traps
+// like on any other failure, so running out of memory could only be guessed from the memory size.
+// The Engine removes the maximum encoded in the module instead, see wasm.RemoveMemoryMax, so every grow
+// reaches the allocator, which records the grows it refuses.
+type memoryAllocator struct {
+	limit uint64
+	// refused is the size of the last grow refused since the last reset, or zero
+	refused atomic.Uint64
+}
+
+func newMemoryAllocator(limit uint64) *memoryAllocator {
+	return &memoryAllocator{limit: limit}
+}
+
+// Allocate implements experimental.MemoryAllocator.
+func (a *memoryAllocator) Allocate(cap, _ uint64) experimental.LinearMemory {
+	return &linearMemory{allocator: a, buf: make([]byte, 0, min(cap, a.limit))}
+}
+
+// resetRefused forgets grows refused before a module call.
+func (a *memoryAllocator) resetRefused() {
+	a.refused.Store(0)
+}
+
+// linearMemory grows its buffer like append does, so it may move. Bergamot memory is not shared,
+// since the module is built without threads, so wazero allows that.
+type linearMemory struct {
+	allocator *memoryAllocator
+	buf       []byte
+}
+
+// Reallocate implements experimental.LinearMemory.
+func (m *linearMemory) Reallocate(size uint64) []byte {
+	if size > m.allocator.limit {
+		m.allocator.refused.Store(size)
+		return nil
+	}
+	if size <= uint64(cap(m.buf)) {
+		// memory never shrinks, so bytes past the length have never been used
+		m.buf = m.buf[:size]
+		return m.buf
+	}
+	buf := make([]byte, size, min(max(size, 2*uint64(cap(m.buf))), m.allocator.limit))
+	copy(buf, m.buf)
+	m.buf = buf
+	return buf
+}
+
+// Free implements experimental.LinearMemory.
+func (m *linearMemory) Free() {
+	m.buf = nil
+}
+
+// outOfMemoryError marks a trap of the module as ErrOutOfMemory if the memory allocator has refused to grow
+// the memory during the failed call. The trap is kept in the chain, so both errors.Is(err, ErrOutOfMemory)
+// and WASMTrapError conversion work.
+func outOfMemoryError(allocator *memoryAllocator, err error) error {
+	var trap *WASMTrapError
+	if allocator == nil || !errors.As(err, &trap) || errors.Is(err, ErrOutOfMemory) {
+		return err
+	}
+	refused := allocator.refused.Load()
+	if refused == 0 {
+		return err
+	}
+	return fmt.Errorf("%w: failed to grow memory to %d bytes over the limit of %d bytes: %w",
+		ErrOutOfMemory, refused, allocator.limit, err)
+}
@@ -0,0 +1,132 @@
+package gobergamot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	embind "github.com/jerbob92/wazero-emscripten-embind"
+
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+// trackedObject is an embind object with the name of its class, which is used in leak reports.
+type trackedObject struct {
+	class  string
+	object embind.ClassBase
+}
+
+func (o trackedObject) isDeleted(ctx context.Context) bool {
+	return o.object.IsInstanceDeleted(ctx, o.object)
+}
+
+func (o trackedObject) delete(ctx context.Context) error {
+	if err := o.object.DeleteInstance(ctx, o.object); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", o.class, err)
+	}
+	return nil
+}
+
+// embindObjects keeps embind objects created in a module instance. They live in WASM memory
+// until deleted explicitly, so objects of the instance are deleted on Close, and objects of a call
+// are deleted once it returns, even if some code path has missed them.
+type embindObjects struct {
+	// instance objects live as long as the module instance
+	instance []trackedObject
+	// call objects are created by the current translation
+	call []trackedObject
+	// emvalHandles is a number of emval handles held before the current translation,
+	// it is counted only in debug builds
+	emvalHandles int
+}
+
+// keep tracks an object living as long as the module instance.
+func (o *embindObjects) keep(class string, object embind.ClassBase) {
+	o.instance = append(o.instance, trackedObject{class: class, object: object})
+}
+
+// track tracks an object which must be deleted by the end of the current translation.
+func (o *embindObjects) track(class string, object embind.ClassBase) {
+	o.call = append(o.call, trackedObject{class: class, object: object})
+}
+
+// releaseCall deletes objects of the current translation which are still alive,
+// and returns their classes. Objects deleted by the translation are just forgotten.
+func (o *embindObjects) releaseCall(ctx context.Context) (leaked []string, err error) {
+	var errs []error
+	for _, object := range o.call {
+		if object.isDeleted(ctx) {
+			continue
+		}
+		leaked = append(leaked, object.class)
+		errs = append(errs, object.delete(ctx))
+	}
+	o.call = o.call[:0]
+	return leaked, errors.Join(errs...)
+}
+
+// discardCall forgets objects of the current translation without deleting them.
+// It is used when the module state is lost, e.g. after a trap or a memory snapshot restore.
+func (o *embindObjects) discardCall() {
+	o.call = o.call[:0]
+}
+
+// releaseInstance deletes objects of the module instance in reverse order of creation,
+// so objects are deleted before ones they were created from.
+func (o *embindObjects) releaseInstance(ctx context.Context) error {
+	var errs []error
+	for i := len(o.instance) - 1; i >= 0; i-- {
+		if object := o.instance[i]; !object.isDeleted(ctx) {
+			errs = append(errs, object.delete(ctx))
+		}
+	}
+	o.instance = nil
+	return errors.Join(errs...)
+}
+
+// maxLeakedObjects is the number of leaks kept for LeakedObjects, so a long-running Translator
+// leaking on every translation does not grow without bound.
+const maxLeakedObjects = 256
+
+// LeakedObjects returns embind classes of objects and emval handles which translations have left
+// alive in WASM memory, and the Translator has deleted on its own. Leaks are only detected
+// in builds with gobergamot_debug tag, so it always returns nil in release builds.
+// Only the first 256 leaks are returned, but every leak is also logged with the Error level.
+func (t *Translator) LeakedObjects() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.leaked)
+}
+
+// beginCall prepares leak detection of the translation.
+func (t *Translator) beginCall() {
+	if wasm.DebugBuild {
+		t.objects.emvalHandles = t.embindEngine.CountEmvalHandles()
+	}
+}
+
+// endCall deletes objects the translation has left alive. If the translation has failed with err,
+// which makes the module state unknown, objects are forgotten instead, since deleting them may trap again.
+func (t *Translator) endCall(ctx context.Context, err error) {
+	var trap *WASMTrapError
+	if errors.As(err, &trap) || t.module.IsClosed() {
+		t.objects.discardCall()
+		return
+	}
+	leaked, deleteErr := t.objects.releaseCall(ctx)
+	if deleteErr != nil {
+		t.logger.Error("failed to delete objects left by translation", slog.Any("error", deleteErr))
+	}
+	if !wasm.DebugBuild {
+		return
+	}
+	if handles := t.embindEngine.CountEmvalHandles() - t.objects.emvalHandles; handles > 0 {
+		leaked = append(leaked, fmt.Sprintf("%d emval handles", handles))
+	}
+	if len(leaked) != 0 {
+		t.leaked = append(t.leaked, leaked[:min(len(leaked), maxLeakedObjects-len(t.leaked))]...)
+		t.logger.Error("translation leaked embind objects", slog.Any("objects", leaked))
+	}
+}
@@ -1,22 +1,59 @@
 package gobergamot
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
-
-	"github.com/tetratelabs/wazero"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/xxnuo/gobergamot/internal/errgroup"
 )
 
-var ErrClosed = errors.New("pool closed")
+// errPoolClosed is returned by Pool calls after Close.
+var errPoolClosed = fmt.Errorf("pool %w", ErrClosed)
 
 type PoolConfig struct {
 	Config
 	PoolSize uint
+
+	// Tenants configures how workers are shared between tenants set with WithTenant.
+	// Requests without tenant belong to the tenant with empty key.
+	Tenants map[string]TenantConfig
+	// DefaultTenant configures tenants missing in Tenants.
+	DefaultTenant TenantConfig
+
+	// ShardWords enables splitting of large TranslateMultiple batches between idle workers.
+	// A batch having at least 2*ShardWords words may be split into shards, each having at least
+	// ShardWords words, which are translated concurrently. Zero disables splitting.
+	ShardWords uint
+
+	// StickyRouting enables routing requests with the same texts to the same worker,
+	// so they hit Bergamot translation cache of that worker (see Config.CacheSize).
+	// If the preferred worker is busy, the request is given to any free worker.
+	// Recycled translators start with an empty cache, including ones restored
+	// from a memory snapshot (see Config.SnapshotMemory).
+	StickyRouting bool
+
+	// Recycle defines when workers replace their translators with new ones.
+	// Replacements are built in background, so workers keep processing requests meanwhile.
+	// A worker with a poisoned translator takes no requests until its replacement is ready.
+	Recycle RecyclePolicy
+
+	// Progress is called each time a worker translator is built by NewPool or Resize,
+	// with the number of ready translators and the total number being built.
+	// Calls are serialized, so Progress does not need to be safe for concurrent use.
+	Progress func(ready, total uint)
+
+	// Warmup enables translating WarmupSamples by every worker before it starts to process requests.
+	// Pool.Ready can be used to wait for warmed up workers.
+	Warmup bool
+	// WarmupSamples are requests used for warmup. If empty, DefaultWarmupSamples are used.
+	WarmupSamples []TranslationRequest
 }
 
 func (cfg PoolConfig) Validate() error {
@@ -36,53 +73,181 @@ func NewPool(ctx context.Context, cfg PoolConfig) (*Pool, error) {
 	if cfg.BergamotOptions == nil {
 		cfg.BergamotOptions = DefaultBergamotOptions()
 	}
-	if cfg.Config.WASMCache == nil {
-		// using cache to speed up workers creation
-		cfg.Config.WASMCache = wazero.NewCompilationCache()
+	// workers share the runtime, compiled module and host modules
+	engine, err := NewEngine(ctx, cfg.engineConfig())
+	if err != nil {
+		return nil, err
 	}
 	p := &Pool{
+		engine:  engine,
+		logger:  loggerOrDiscard(cfg.Logger),
 		cfg:     cfg,
-		reqChan: make(chan workerRequest),
+		sched:   newScheduler(cfg.Tenants, cfg.DefaultTenant, cfg.StickyRouting),
 		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		ready:   make(chan struct{}),
 		eg:      errgroup.New(),
 	}
 	// converting Config FileBundle into byte slices
 	// to share between workers to read
-	if err = filesToBytes(p); err != nil {
+	if p.files, err = readBundle(cfg.FilesBundle); err != nil {
+		p.closeEngine()
 		return nil, err
 	}
 
-	translators, err := p.buildTranslators(ctx)
+	translators, err := p.buildTranslators(ctx, cfg.PoolSize, cfg.Progress)
 	if err != nil {
+		p.closeEngine()
+		p.logger.Error("failed to create pool", slog.Any("error", err))
 		return nil, fmt.Errorf("failed to setup translators: %w", err)
 	}
 
+	p.warming.Add(len(translators))
 	for i := range translators {
+		w := newPoolWorker(translators[i], i, cfg.Recycle.MaxAge)
+		p.workers = append(p.workers, w)
 		p.eg.Go(func() error {
-			return p.runWorker(translators[i])
+			p.warmupWorker(w)
+			return p.runWorker(w)
 		})
 	}
+	p.sched.setSize(len(p.workers))
+	go func() {
+		p.warming.Wait()
+		close(p.ready)
+	}()
 
+	p.logger.Info("pool created", slog.Int("size", len(p.workers)))
 	return p, nil
 }
 
 type Pool struct {
-	cfg PoolConfig
+	cfg    PoolConfig
+	logger *slog.Logger
 
-	reqChan chan workerRequest
+	sched *scheduler
 
 	eg   errgroup.Errgroup
 	done chan struct{}
 
-	modelBytes        []byte
-	shortlistBytes    []byte
-	vocabulariesBytes [][]byte
+	// closing is set when the Pool stops accepting new requests,
+	// inflight tracks TranslateMultiple calls accepted before that.
+	mu       sync.RWMutex
+	closing  bool
+	inflight sync.WaitGroup
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+	stopErr  error
+	// background tracks goroutines building recycled translators
+	background sync.WaitGroup
+
+	// engine creates worker translators, it is closed after workers
+	engine *Engine
+
+	// workersMu guards workers, which are changed by Resize
+	workersMu sync.RWMutex
+	workers   []*poolWorker
+	resizeMu  sync.Mutex
+
+	stats poolCounters
+
+	// ready is closed when all workers have completed warmup
+	ready     chan struct{}
+	warming   sync.WaitGroup
+	warmupMu  sync.Mutex
+	warmupErr error
+
+	files bundleBytes
+}
+
+type poolWorker struct {
+	// index is the worker position in Pool workers
+	index    int
+	busy     atomic.Bool
+	requests atomic.Uint64
+	memory   atomic.Uint64
+
+	// translator and its counters are accessed only by the worker goroutine
+	translator         *Translator
+	translatorRequests uint64
+	translatorCreated  time.Time
+
+	// maxAge is RecyclePolicy.MaxAge, ageTimer wakes the worker up when its translator reaches it
+	maxAge   time.Duration
+	ageTimer *time.Timer
+
+	// recycling is set while a replacement translator is built, which is put to replacement
+	recycling   atomic.Bool
+	replacement atomic.Pointer[Translator]
+	// wake is signalled to make the idle worker take the replacement or check the recycle policy
+	wake chan struct{}
+
+	// stop is closed to remove the worker from the Pool,
+	// exited is closed when the worker has closed its translator
+	stop   chan struct{}
+	exited chan struct{}
+}
+
+func newPoolWorker(translator *Translator, index int, maxAge time.Duration) *poolWorker {
+	w := &poolWorker{
+		index:  index,
+		maxAge: maxAge,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	w.setTranslator(translator)
+	return w
+}
+
+func (w *poolWorker) setTranslator(translator *Translator) {
+	w.translator = translator
+	w.translatorRequests = 0
+	w.resetAge()
+	w.memory.Store(translator.memorySize())
+}
+
+// resetAge starts counting the translator age, so the worker is woken up when it reaches maxAge
+// even if there are no requests.
+func (w *poolWorker) resetAge() {
+	w.translatorCreated = time.Now()
+	if w.maxAge <= 0 {
+		return
+	}
+	if w.ageTimer == nil {
+		w.ageTimer = time.AfterFunc(w.maxAge, w.signal)
+		return
+	}
+	w.ageTimer.Reset(w.maxAge)
+}
+
+// signal wakes the worker up if it is idle, or makes it check the replacement after the current request.
+func (w *poolWorker) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
 }
 
 type workerRequest struct {
-	ctx      context.Context
-	reqs     []TranslationRequest
-	respChan chan workerResponse
+	ctx        context.Context
+	reqs       []TranslationRequest
+	respChan   chan workerResponse
+	enqueuedAt time.Time
+
+	tenant string
+	// batch is set if the request is a shard of a caller batch
+	batch *shardBatch
+	// characters is a number of runes in texts of reqs
+	characters int
+	// affinity is a hash of texts of reqs used for sticky routing
+	affinity uint64
+}
+
+// cost is the amount of work used for fair scheduling between tenants.
+func (r *workerRequest) cost() int {
+	return max(r.characters, 1)
 }
 
 type workerResponse struct {
@@ -104,114 +269,341 @@ func (p *Pool) Translate(ctx context.Context, request TranslationRequest) (strin
 }
 
 // TranslateMultiple is similar to Translator.TranslateMultiple except the requests are asynchronously given
-// to any free worker in the pool.
+// to any free worker in the pool. If PoolConfig.ShardWords is set, large batches are split between
+// several idle workers and the outputs are returned in the original order.
 func (p *Pool) TranslateMultiple(ctx context.Context, requests ...TranslationRequest) ([]string, error) {
-	req := workerRequest{
-		ctx:      ctx,
-		reqs:     requests,
-		respChan: make(chan workerResponse, 1),
+	p.mu.RLock()
+	if p.closing {
+		p.mu.RUnlock()
+		return nil, fmt.Errorf("did not found available worker: %w", errPoolClosed)
 	}
-	select {
-	case <-p.done:
-		return nil, fmt.Errorf("did not found available worker: %w", ErrClosed)
-	case <-ctx.Done():
-		return nil, fmt.Errorf("did not found available worker: %w", ctx.Err())
-	case p.reqChan <- req:
+	p.inflight.Add(1)
+	p.mu.RUnlock()
+	defer p.inflight.Done()
+
+	shards := p.shard(requests)
+	if len(shards) == 1 {
+		return p.wait(ctx, p.submit(ctx, requests, nil))
+	}
+
+	batch := newShardBatch(len(shards))
+	submitted := make([]*workerRequest, len(shards))
+	for i := range shards {
+		submitted[i] = p.submit(ctx, shards[i], batch)
+	}
+	outputs := make([]string, 0, len(requests))
+	for i, req := range submitted {
+		shardOutputs, err := p.wait(ctx, req)
+		if err != nil {
+			// shards not taken by workers yet are not needed anymore
+			for _, rest := range submitted[i+1:] {
+				p.withdraw(rest)
+			}
+			return nil, err
+		}
+		outputs = append(outputs, shardOutputs...)
+	}
+	return outputs, nil
+}
+
+func (p *Pool) submit(ctx context.Context, requests []TranslationRequest, batch *shardBatch) *workerRequest {
+	req := &workerRequest{
+		ctx:        ctx,
+		reqs:       requests,
+		respChan:   make(chan workerResponse, 1),
+		enqueuedAt: time.Now(),
+		tenant:     TenantFromContext(ctx),
+		batch:      batch,
+	}
+	for i := range requests {
+		req.characters += utf8.RuneCountInString(requests[i].Text)
+	}
+	if p.cfg.StickyRouting {
+		req.affinity = requestsHash(requests)
 	}
+	p.sched.push(req)
+	return req
+}
 
+func (p *Pool) wait(ctx context.Context, req *workerRequest) ([]string, error) {
 	select {
 	case <-p.done:
-		return nil, fmt.Errorf("failed to wait response: %w", ErrClosed)
+		if p.withdraw(req) {
+			return nil, fmt.Errorf("did not found available worker: %w", errPoolClosed)
+		}
+		return nil, fmt.Errorf("failed to wait response: %w", errPoolClosed)
 	case <-ctx.Done():
+		if p.withdraw(req) {
+			return nil, fmt.Errorf("did not found available worker: %w", ctx.Err())
+		}
 		return nil, fmt.Errorf("failed to wait response: %w", ctx.Err())
 	case resp := <-req.respChan:
 		return resp.outputs, resp.err
 	}
 }
 
-// Close closes existing Translator instances and waits for their completion
+// withdraw removes the request from the queue, see scheduler.remove.
+// A withdrawn shard may be the last one to complete its batch.
+func (p *Pool) withdraw(req *workerRequest) bool {
+	if !p.sched.remove(req) {
+		return false
+	}
+	if req.batch != nil {
+		if completed, failed := req.batch.done(false, false); completed {
+			p.countRequest(failed)
+			p.sched.countRequest(req.tenant, failed)
+		}
+	}
+	return true
+}
+
+// complete accounts the request processed by a worker. It reports if the caller request is completed,
+// which happens after the last shard of a batch, and if it has failed.
+func (p *Pool) complete(req *workerRequest, err error) (completed, failed bool) {
+	completed, failed = true, err != nil
+	if req.batch != nil {
+		completed, failed = req.batch.done(true, err != nil)
+	}
+	if completed {
+		p.countRequest(failed)
+	}
+	return completed, failed
+}
+
+func (p *Pool) countRequest(failed bool) {
+	p.stats.requests.Add(1)
+	if failed {
+		p.stats.errors.Add(1)
+	}
+}
+
+// Close closes existing Translator instances and waits for their completion.
+// Requests waiting for a worker or a response are failed with ErrClosed.
 func (p *Pool) Close(ctx context.Context) error {
-	close(p.done)
+	p.rejectRequests()
+	p.stop()
+	return p.waitStopped(ctx)
+}
+
+// Shutdown gracefully closes the Pool: it stops accepting new requests, waits until
+// already accepted requests (both queued and in-flight) are translated and then closes
+// Translator instances. If ctx expires before that, Shutdown returns the context error
+// and the Pool keeps draining; Close can be used to abort remaining requests.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.rejectRequests()
 
-	errCh := make(chan error)
+	drained := make(chan struct{})
 	go func() {
-		errCh <- p.eg.Wait()
+		defer close(drained)
+		p.inflight.Wait()
 	}()
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case err := <-errCh:
-		return err
+	case <-drained:
+	}
+
+	p.stop()
+	return p.waitStopped(ctx)
+}
+
+func (p *Pool) rejectRequests() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closing = true
+}
+
+// stop signals workers to close their translators. It is safe to call stop multiple times.
+func (p *Pool) stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+		go func() {
+			p.stopErr = p.eg.Wait()
+			p.background.Wait()
+			p.closeEngine()
+			p.logger.Info("pool closed")
+			close(p.stopped)
+		}()
+	})
+}
+
+// closeEngine releases the runtime and compiled code shared by workers.
+func (p *Pool) closeEngine() {
+	_ = p.engine.Close(context.Background())
+}
+
+func (p *Pool) waitStopped(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.stopped:
+		return p.stopErr
 	}
 }
 
-func (p *Pool) runWorker(translator *Translator) error {
+// ExecutionMode returns the mode used to run worker modules, which is either ExecutionCompiler or ExecutionInterpreter.
+func (p *Pool) ExecutionMode() ExecutionMode {
+	return p.engine.ExecutionMode()
+}
+
+// Stats returns a snapshot of the Pool workers state and cumulative translation statistics.
+func (p *Pool) Stats() PoolStats {
+	p.workersMu.RLock()
+	defer p.workersMu.RUnlock()
+
+	stats := PoolStats{
+		QueueLength: p.sched.queueLength(),
+		Workers:     make([]WorkerStats, len(p.workers)),
+		Recycled:    p.stats.recycled.Load(),
+		Requests:    p.stats.requests.Load(),
+		Shards:      p.stats.shards.Load(),
+		Sentences:   p.stats.sentences.Load(),
+		Characters:  p.stats.characters.Load(),
+		Errors:      p.stats.errors.Load(),
+		QueueWait:   p.stats.queueWait.stats(),
+		Translation: p.stats.translation.stats(),
+		Tenants:     p.sched.tenantsStats(),
+	}
+	for i, w := range p.workers {
+		stats.Workers[i] = WorkerStats{
+			Busy:        w.busy.Load(),
+			Requests:    w.requests.Load(),
+			MemoryBytes: w.memory.Load(),
+		}
+		if stats.Workers[i].Busy {
+			stats.BusyWorkers++
+		} else {
+			stats.IdleWorkers++
+		}
+	}
+	return stats
+}
+
+func (p *Pool) runWorker(w *poolWorker) error {
+	defer func() {
+		close(w.exited)
+		// a replacement put after this is closed by its builder, see recycleIfDue
+		if translator := w.replacement.Swap(nil); translator != nil {
+			_ = translator.Close(context.Background())
+		}
+	}()
+	p.logger.Debug("worker started", slog.Int("worker", w.index))
+	defer p.logger.Debug("worker stopped", slog.Int("worker", w.index))
 	for {
-		select {
-		case <-p.done:
-			return translator.Close(context.Background())
-		case req := <-p.reqChan:
-			var resp workerResponse
-			resp.outputs, resp.err = translator.TranslateMultiple(req.ctx, req.reqs...)
-			req.respChan <- resp
+		if translator := w.replacement.Swap(nil); translator != nil {
+			p.replaceTranslator(w, translator)
+		}
+		if w.translator.Poisoned() != nil {
+			// poisoned translator fails every request, so the worker takes none until it is replaced
+			if !p.awaitReplacement(w) {
+				return p.exitWorker(w)
+			}
+			continue
+		}
+
+		req, ok := p.sched.next(p.done, w)
+		if !ok {
+			return p.exitWorker(w)
+		}
+		if req == nil {
+			// the idle worker is woken up to take the replacement or because its translator is too old
+			p.recycleIfDue(w)
+			continue
 		}
+		resp := p.process(w, req)
+		completed, failed := p.complete(req, resp.err)
+		p.sched.finish(req, resp.err, completed, failed)
+		req.respChan <- resp
+
+		p.recycleIfDue(w)
+	}
+}
+
+// exitWorker closes the translator of the worker removed from the Pool or stopped along with it.
+func (p *Pool) exitWorker(w *poolWorker) error {
+	if w.ageTimer != nil {
+		w.ageTimer.Stop()
 	}
+	return w.translator.Close(context.Background())
 }
 
-func (p *Pool) buildTranslators(ctx context.Context) ([]*Translator, error) {
+func (p *Pool) process(w *poolWorker, req *workerRequest) workerResponse {
+	w.busy.Store(true)
+	defer w.busy.Store(false)
+
+	start := time.Now()
+	p.stats.queueWait.observe(start.Sub(req.enqueuedAt))
+	p.logger.Debug("request dispatched", slog.Int("worker", w.index), slog.String("tenant", req.tenant))
+
+	result, err := w.translator.translate(req.ctx, req.reqs)
+	p.stats.translation.observe(time.Since(start))
+	w.requests.Add(1)
+	w.translatorRequests++
+	w.memory.Store(w.translator.memorySize())
+	p.stats.shards.Add(1)
+	if err != nil {
+		return workerResponse{err: err}
+	}
+
+	p.stats.sentences.Add(uint64(result.sentences))
+	p.stats.characters.Add(uint64(req.characters))
+	return workerResponse{outputs: result.outputs}
+}
+
+func requestsHash(requests []TranslationRequest) uint64 {
+	h := fnv.New64a()
+	for i := range requests {
+		_, _ = h.Write([]byte(requests[i].Text))
+		// separator to distinguish ["ab", "c"] from ["a", "bc"]
+		_, _ = h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// buildTranslators creates n translators concurrently. Construction is all-or-nothing:
+// if any translator fails, already built ones are closed. Progress, if not nil,
+// is called after each built translator.
+func (p *Pool) buildTranslators(ctx context.Context, n uint, progress func(ready, total uint)) ([]*Translator, error) {
 	eg := errgroup.New()
 
-	translators := make([]*Translator, p.cfg.PoolSize)
-	for i := uint(0); i < p.cfg.PoolSize; i++ {
+	var (
+		progressMu sync.Mutex
+		ready      uint
+	)
+	translators := make([]*Translator, n)
+	for i := uint(0); i < n; i++ {
 		i := i
 		eg.Go(func() error {
 			cfg := p.cfg.Config
-			cfg.Model = bytes.NewBuffer(p.modelBytes)
-			cfg.LexicalShortlist = bytes.NewBuffer(p.shortlistBytes)
+			cfg.FilesBundle = p.files.filesBundle()
 
-			// Create vocabularies readers
-			vocabularies := make([]io.Reader, len(p.vocabulariesBytes))
-			for j, vocabBytes := range p.vocabulariesBytes {
-				vocabularies[j] = bytes.NewBuffer(vocabBytes)
+			translator, err := p.engine.NewTranslator(ctx, cfg)
+			if err != nil {
+				return err
 			}
-			cfg.Vocabularies = vocabularies
-
-			translator, err := New(ctx, cfg)
 			translators[i] = translator
-			return err
-		})
-	}
-
-	err := eg.Wait()
-	return translators, err
-}
 
-func filesToBytes(p *Pool) error {
-	var err error
-	wrappingFile := new(alignedMemoryFile)
-
-	wrappingFile.Reader = p.cfg.Model
-	p.modelBytes, err = wrappingFile.readAll()
-	if err != nil {
-		return fmt.Errorf("failed to read model: %w", err)
+			if progress != nil {
+				progressMu.Lock()
+				defer progressMu.Unlock()
+				ready++
+				progress(ready, n)
+			}
+			return nil
+		})
 	}
 
-	wrappingFile.Reader = p.cfg.LexicalShortlist
-	p.shortlistBytes, err = wrappingFile.readAll()
-	if err != nil {
-		return fmt.Errorf("failed to read shortlist: %w", err)
+	if err := eg.Wait(); err != nil {
+		closeTranslators(translators)
+		return nil, err
 	}
+	return translators, nil
+}
 
-	// Read all vocabularies
-	p.vocabulariesBytes = make([][]byte, len(p.cfg.Vocabularies))
-	for i, vocab := range p.cfg.Vocabularies {
-		wrappingFile.Reader = vocab
-		vocabBytes, err := wrappingFile.readAll()
-		if err != nil {
-			return fmt.Errorf("failed to read vocabulary %d: %w", i, err)
+func closeTranslators(translators []*Translator) {
+	for _, translator := range translators {
+		if translator != nil {
+			_ = translator.Close(context.Background())
 		}
-		p.vocabulariesBytes[i] = vocabBytes
 	}
-
-	return nil
 }
@@ -0,0 +1,167 @@
+package gobergamot
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// pprofProfile encodes samples as the protobuf message defined by
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+type pprofProfile struct {
+	functions []profiledFunction
+	samples   map[string]*profileSample
+	start     time.Time
+	duration  time.Duration
+	period    time.Duration
+}
+
+// Field numbers of profile.proto messages, named after the message and the field.
+const (
+	fieldProfileSampleType    = 1
+	fieldProfileSample        = 2
+	fieldProfileLocation      = 4
+	fieldProfileFunction      = 5
+	fieldProfileStringTable   = 6
+	fieldProfileTimeNanos     = 9
+	fieldProfileDurationNanos = 10
+	fieldProfilePeriodType    = 11
+	fieldProfilePeriod        = 12
+
+	fieldValueTypeType = 1
+	fieldValueTypeUnit = 2
+
+	fieldSampleLocationID = 1
+	fieldSampleValue      = 2
+
+	fieldLocationID   = 1
+	fieldLocationLine = 4
+
+	fieldLineFunctionID = 1
+	fieldLineLine       = 2
+
+	fieldFunctionID         = 1
+	fieldFunctionName       = 2
+	fieldFunctionSystemName = 3
+	fieldFunctionFilename   = 4
+	fieldFunctionStartLine  = 5
+)
+
+func (p pprofProfile) write(w io.Writer) error {
+	table := []string{""}
+	tableIndex := map[string]uint64{"": 0}
+	str := func(s string) uint64 {
+		i, ok := tableIndex[s]
+		if !ok {
+			i = uint64(len(table))
+			table = append(table, s)
+			tableIndex[s] = i
+		}
+		return i
+	}
+	valueType := func(b *protoBuffer, field int, typ, unit string) {
+		b.message(field, func(b *protoBuffer) {
+			b.uint64(fieldValueTypeType, str(typ))
+			b.uint64(fieldValueTypeUnit, str(unit))
+		})
+	}
+
+	var b protoBuffer
+	valueType(&b, fieldProfileSampleType, "samples", "count")
+	valueType(&b, fieldProfileSampleType, "cpu", "nanoseconds")
+	for _, s := range p.samples {
+		b.message(fieldProfileSample, func(b *protoBuffer) {
+			b.packed(fieldSampleLocationID, s.locations...)
+			b.packed(fieldSampleValue, uint64(s.count), uint64(s.count*p.period.Nanoseconds()))
+		})
+	}
+	// every sampled function has a single location with the same ID
+	sampled := make(map[uint64]bool)
+	for _, s := range p.samples {
+		for _, id := range s.locations {
+			sampled[id] = true
+		}
+	}
+	for i, fn := range p.functions {
+		id := uint64(i + 1)
+		if !sampled[id] {
+			continue
+		}
+		b.message(fieldProfileLocation, func(b *protoBuffer) {
+			b.uint64(fieldLocationID, id)
+			b.message(fieldLocationLine, func(b *protoBuffer) {
+				b.uint64(fieldLineFunctionID, id)
+				b.uint64(fieldLineLine, uint64(fn.line))
+			})
+		})
+		b.message(fieldProfileFunction, func(b *protoBuffer) {
+			b.uint64(fieldFunctionID, id)
+			b.uint64(fieldFunctionName, str(fn.name))
+			b.uint64(fieldFunctionSystemName, str(fn.name))
+			b.uint64(fieldFunctionFilename, str(fn.file))
+			b.uint64(fieldFunctionStartLine, uint64(fn.line))
+		})
+	}
+	b.uint64(fieldProfileTimeNanos, uint64(p.start.UnixNano()))
+	b.uint64(fieldProfileDurationNanos, uint64(p.duration.Nanoseconds()))
+	valueType(&b, fieldProfilePeriodType, "cpu", "nanoseconds")
+	b.uint64(fieldProfilePeriod, uint64(p.period.Nanoseconds()))
+	for _, s := range table {
+		b.bytes(fieldProfileStringTable, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer appends protobuf fields.
+type protoBuffer []byte
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *protoBuffer) tag(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint64 appends a varint field, zero values are omitted like proto3 does.
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuffer) packed(field int, values ...uint64) {
+	var content protoBuffer
+	for _, v := range values {
+		content.varint(v)
+	}
+	b.bytes(field, content)
+}
+
+func (b *protoBuffer) message(field int, encode func(b *protoBuffer)) {
+	var content protoBuffer
+	encode(&content)
+	b.bytes(field, content)
+}
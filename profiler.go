@@ -0,0 +1,226 @@
+package gobergamot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+// DefaultProfilerInterval is the sampling interval used by Profiler if zero is passed to NewProfiler.
+const DefaultProfilerInterval = 10 * time.Millisecond
+
+var ErrProfilerRunning = errors.New("profiler is already running")
+
+// Profiler samples WASM call stacks of Translators and writes them as a pprof CPU profile,
+// which shows where translation time goes inside the module: GEMM, beam search, SentencePiece
+// or HTML processing. Functions are named from the module name section, and if the module
+// has DWARF sections (see DebugInfo), with qualified C++ names and source locations.
+// Host functions, like wasm_gemm ones, are named after their import module.
+//
+// The Profiler is set with EngineConfig.Profiler or Config.Profiler. wazero function listeners
+// it relies on are compiled into the module, so every WASM call is slower even when the Profiler
+// is stopped. Such an Engine must be used only to profile. The Profiler may be shared by Engines.
+type Profiler struct {
+	interval time.Duration
+
+	// stacks are *callStack of module instances executing functions by their api.Module
+	stacks sync.Map
+
+	// mu guards the fields below
+	mu sync.Mutex
+	// functions are listened functions, which IDs are their indexes plus one
+	functions []profiledFunction
+	// samples are counted by their locations encoded into a string
+	samples map[string]*profileSample
+	w       io.Writer
+	start   time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type profiledFunction struct {
+	name, file string
+	line       int64
+}
+
+type profileSample struct {
+	// locations are IDs of functions from the callee to the outermost caller
+	locations []uint64
+	count     int64
+}
+
+type callStack struct {
+	mu     sync.Mutex
+	frames []uint64
+}
+
+// NewProfiler creates a stopped Profiler, which takes a sample every interval when started.
+// If interval is zero, DefaultProfilerInterval is used.
+func NewProfiler(interval time.Duration) *Profiler {
+	if interval <= 0 {
+		interval = DefaultProfilerInterval
+	}
+	return &Profiler{interval: interval}
+}
+
+// Start starts sampling, the profile is written to w by Stop.
+// It returns ErrProfilerRunning if the Profiler is already started.
+func (p *Profiler) Start(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		return ErrProfilerRunning
+	}
+	p.w, p.start = w, time.Now()
+	p.samples = make(map[string]*profileSample)
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	go p.sample(p.stop, p.done)
+	return nil
+}
+
+// Stop stops sampling and writes gzip-compressed pprof profile to the writer passed to Start.
+// It does nothing if the Profiler is not started.
+func (p *Profiler) Stop() error {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	profile := pprofProfile{
+		functions: p.functions,
+		samples:   p.samples,
+		start:     p.start,
+		duration:  time.Since(p.start),
+		period:    p.interval,
+	}
+	err := profile.write(p.w)
+	p.w, p.samples, p.stop, p.done = nil, nil, nil, nil
+	if err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	return nil
+}
+
+func (p *Profiler) sample(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var (
+		frames []uint64
+		key    []byte
+	)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		p.stacks.Range(func(_, v any) bool {
+			stack := v.(*callStack)
+			stack.mu.Lock()
+			frames = append(frames[:0], stack.frames...)
+			stack.mu.Unlock()
+			if len(frames) == 0 {
+				return true
+			}
+
+			key = key[:0]
+			for _, id := range frames {
+				key = binary.AppendUvarint(key, id)
+			}
+			p.mu.Lock()
+			s, ok := p.samples[string(key)]
+			if !ok {
+				s = &profileSample{locations: make([]uint64, len(frames))}
+				for i, id := range frames {
+					s.locations[len(frames)-1-i] = id
+				}
+				p.samples[string(key)] = s
+			}
+			s.count++
+			p.mu.Unlock()
+			return true
+		})
+	}
+}
+
+// listenerFactory returns factory of listeners tracking call stacks of module instances.
+// sources are C++ locations of the module functions, which may be nil.
+func (p *Profiler) listenerFactory(sources map[uint32]wasm.FunctionSource) experimental.FunctionListenerFactory {
+	return experimental.FunctionListenerFactoryFunc(func(def api.FunctionDefinition) experimental.FunctionListener {
+		fn := profiledFunction{name: def.Name()}
+		switch source, ok := sources[def.Index()]; {
+		case def.GoFunction() != nil:
+			fn.name = def.ModuleName() + "." + def.Name()
+		case ok:
+			fn = profiledFunction{name: source.Name, file: source.File, line: source.Line}
+		case fn.name == "":
+			fn.name = fmt.Sprintf("wasm-function[%d]", def.Index())
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.functions = append(p.functions, fn)
+		return &profilerListener{profiler: p, id: uint64(len(p.functions))}
+	})
+}
+
+type profilerListener struct {
+	profiler *Profiler
+	id       uint64
+}
+
+func (l *profilerListener) Before(_ context.Context, mod api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+	v, ok := l.profiler.stacks.Load(mod)
+	if !ok {
+		v, _ = l.profiler.stacks.LoadOrStore(mod, &callStack{})
+	}
+	stack := v.(*callStack)
+	stack.mu.Lock()
+	stack.frames = append(stack.frames, l.id)
+	stack.mu.Unlock()
+}
+
+func (l *profilerListener) After(_ context.Context, mod api.Module, _ api.FunctionDefinition, _ []uint64) {
+	l.pop(mod)
+}
+
+func (l *profilerListener) Abort(_ context.Context, mod api.Module, _ api.FunctionDefinition, _ error) {
+	l.pop(mod)
+}
+
+// pop removes the callee from the stack, and the stack itself once the module returns,
+// so stacks of closed modules are not kept.
+func (l *profilerListener) pop(mod api.Module) {
+	v, ok := l.profiler.stacks.Load(mod)
+	if !ok {
+		return
+	}
+	stack := v.(*callStack)
+	stack.mu.Lock()
+	if len(stack.frames) != 0 {
+		stack.frames = stack.frames[:len(stack.frames)-1]
+	}
+	empty := len(stack.frames) == 0
+	stack.mu.Unlock()
+	if empty {
+		// a module instance is called by one goroutine at a time, so nothing is pushed concurrently
+		l.profiler.stacks.Delete(mod)
+	}
+}
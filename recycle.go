@@ -0,0 +1,132 @@
+package gobergamot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RecyclePolicy defines when a Pool worker replaces its Translator with a new one.
+// WASM linear memory never shrinks, so recycling returns memory grown by large inputs.
+// Zero values disable the corresponding limit.
+type RecyclePolicy struct {
+	// MaxRequests is a number of requests after which the translator is recycled
+	MaxRequests uint64
+	// MaxMemoryBytes is a size of WASM linear memory after reaching which the translator is recycled
+	MaxMemoryBytes uint64
+	// MaxAge is a lifetime after which the translator is recycled, even if the worker is idle
+	MaxAge time.Duration
+}
+
+func (rp RecyclePolicy) due(w *poolWorker) bool {
+	switch {
+	case rp.MaxRequests > 0 && w.translatorRequests >= rp.MaxRequests:
+		return true
+	case rp.MaxMemoryBytes > 0 && w.memory.Load() >= rp.MaxMemoryBytes:
+		return true
+	case rp.MaxAge > 0 && time.Since(w.translatorCreated) >= rp.MaxAge:
+		return true
+	default:
+		return false
+	}
+}
+
+// replacementRetryInterval is the time a worker with poisoned translator waits before building
+// a replacement again after a failed attempt.
+const replacementRetryInterval = time.Second
+
+// recycleIfDue starts building a replacement for the worker translator if the recycle policy says so
+// or the translator is poisoned. The worker keeps processing requests with the old translator
+// until the new one is ready, unless it is poisoned, see awaitReplacement.
+func (p *Pool) recycleIfDue(w *poolWorker) {
+	due := p.cfg.Recycle.due(w) || w.translator.Poisoned() != nil
+	if !due || p.resetTranslator(w) || !w.recycling.CompareAndSwap(false, true) {
+		return
+	}
+
+	// background work must not be started after the Pool has been stopped
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closing {
+		return
+	}
+	p.background.Add(1)
+
+	go func() {
+		defer p.background.Done()
+		ctx := context.Background()
+
+		translators, err := p.buildTranslators(ctx, 1, nil)
+		if err == nil {
+			if err = p.warmup(ctx, translators[0]); err != nil {
+				closeTranslators(translators)
+			}
+		}
+		if err != nil {
+			p.logger.Warn("failed to build replacement translator", slog.Int("worker", w.index), slog.Any("error", err))
+			// trying again after next request
+			w.recycling.Store(false)
+			return
+		}
+
+		w.replacement.Store(translators[0])
+		w.signal()
+		select {
+		case <-w.exited:
+			// the worker has exited without taking the replacement
+			if translator := w.replacement.Swap(nil); translator != nil {
+				closeTranslators([]*Translator{translator})
+			}
+		default:
+		}
+	}()
+}
+
+// awaitReplacement recycles the poisoned translator of the worker and waits until the replacement
+// is ready, so the worker does not take requests in the meantime. Failed builds are retried after
+// replacementRetryInterval. It returns false if the worker must exit.
+func (p *Pool) awaitReplacement(w *poolWorker) bool {
+	retry := time.NewTimer(0)
+	defer retry.Stop()
+	for w.replacement.Load() == nil && w.translator.Poisoned() != nil {
+		select {
+		case <-p.done:
+			return false
+		case <-w.stop:
+			return false
+		case <-w.wake:
+		case <-retry.C:
+			// the translator is restored in place or a replacement is built in background
+			p.recycleIfDue(w)
+			retry.Reset(replacementRetryInterval)
+		}
+	}
+	return true
+}
+
+// resetTranslator recycles the worker translator in place by restoring its memory snapshot.
+// It is not possible if the memory has grown beyond the limit, since memory never shrinks.
+func (p *Pool) resetTranslator(w *poolWorker) bool {
+	maxMemory := p.cfg.Recycle.MaxMemoryBytes
+	if w.translator.snapshot == nil || (maxMemory > 0 && w.memory.Load() >= maxMemory) {
+		return false
+	}
+	if err := w.translator.resetToSnapshot(context.Background()); err != nil {
+		return false
+	}
+	w.translatorRequests = 0
+	w.resetAge()
+	p.stats.recycled.Add(1)
+	p.logger.Debug("translator recycled in place", slog.Int("worker", w.index))
+	return true
+}
+
+// replaceTranslator is called by the worker to switch to the recycled translator.
+func (p *Pool) replaceTranslator(w *poolWorker, translator *Translator) {
+	old := w.translator
+	w.setTranslator(translator)
+	w.recycling.Store(false)
+	p.stats.recycled.Add(1)
+	p.logger.Info("translator recycled", slog.Int("worker", w.index))
+	_ = old.Close(context.Background())
+}
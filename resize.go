@@ -0,0 +1,96 @@
+package gobergamot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Size returns the current number of workers in the Pool.
+func (p *Pool) Size() int {
+	p.workersMu.RLock()
+	defer p.workersMu.RUnlock()
+	return len(p.workers)
+}
+
+// Resize changes the number of workers in the Pool to n.
+//
+// New workers are created from the model data read by NewPool and share its compilation cache.
+// They start taking requests only after they are built and warmed up (if PoolConfig.Warmup is enabled).
+// Removed workers finish their current request and close their translators; Resize waits for that
+// until ctx is done.
+func (p *Pool) Resize(ctx context.Context, n uint) error {
+	if n == 0 {
+		return errors.New("zero pool size")
+	}
+
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+
+	current := uint(p.Size())
+	switch {
+	case n > current:
+		return p.addWorkers(ctx, n-current)
+	case n < current:
+		return p.removeWorkers(ctx, current-n)
+	default:
+		return nil
+	}
+}
+
+func (p *Pool) addWorkers(ctx context.Context, n uint) error {
+	translators, err := p.buildTranslators(ctx, n, p.cfg.Progress)
+	if err != nil {
+		return fmt.Errorf("failed to setup translators: %w", err)
+	}
+	for _, translator := range translators {
+		if err := p.warmup(ctx, translator); err != nil {
+			closeTranslators(translators)
+			return fmt.Errorf("failed to warm up translators: %w", err)
+		}
+	}
+
+	// workers must not be started after the Pool has been stopped
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closing {
+		closeTranslators(translators)
+		return errPoolClosed
+	}
+
+	p.workersMu.Lock()
+	defer p.workersMu.Unlock()
+	for _, translator := range translators {
+		w := newPoolWorker(translator, len(p.workers), p.cfg.Recycle.MaxAge)
+		p.workers = append(p.workers, w)
+		p.eg.Go(func() error {
+			return p.runWorker(w)
+		})
+	}
+	p.sched.setSize(len(p.workers))
+	return nil
+}
+
+func (p *Pool) removeWorkers(ctx context.Context, n uint) error {
+	// workers are removed from the end, so indexes of remaining workers
+	// and sticky routing of their requests stay the same
+	p.workersMu.Lock()
+	keep := len(p.workers) - int(n)
+	removed := p.workers[keep:]
+	p.workers = slices.Clip(p.workers[:keep])
+	p.workersMu.Unlock()
+	p.sched.setSize(keep)
+
+	for _, w := range removed {
+		close(w.stop)
+	}
+	for _, w := range removed {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.exited:
+		}
+	}
+	return nil
+}
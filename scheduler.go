@@ -0,0 +1,363 @@
+package gobergamot
+
+import (
+	"context"
+	"sync"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying the tenant key. Pool requests made with this context
+// are scheduled according to the tenant configuration in PoolConfig.Tenants.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant key set by WithTenant or an empty string.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// TenantConfig defines how Pool shares workers with the tenant.
+type TenantConfig struct {
+	// Weight is a relative share of workers given to the tenant when several tenants have pending
+	// requests. A tenant with Weight 2 gets twice as much translated characters as a tenant with Weight 1.
+	// Zero is treated as 1.
+	Weight uint
+	// MaxConcurrency limits the number of workers translating the tenant requests at the same time.
+	// Zero means no limit.
+	MaxConcurrency uint
+}
+
+// TenantStats contains statistics of a single tenant.
+type TenantStats struct {
+	// Queued is a number of the tenant requests waiting for a worker
+	Queued int
+	// Running is a number of the tenant requests being translated
+	Running int
+	// Requests is a number of processed tenant requests
+	Requests uint64
+	// Characters is a number of source characters (runes) translated for the tenant
+	Characters uint64
+	// Errors is a number of the tenant requests which failed in a worker
+	Errors uint64
+}
+
+// scheduler distributes requests between workers using stride scheduling:
+// each tenant advances its pass by request cost divided by tenant weight
+// and the next request is taken from the tenant with the least pass.
+type scheduler struct {
+	mu sync.Mutex
+	// tenants are queues of tenants having queued or running requests. A queue is removed once
+	// the tenant becomes idle, since tenant keys come from callers and are not bounded.
+	tenants map[string]*tenantQueue
+	// retained are statistics of idle tenants which are kept: configured ones and the default one
+	retained map[string]TenantStats
+	// vtime is the least pass of active tenants. Tenants becoming active
+	// start from it, so idle time is not accumulated as credit.
+	vtime  float64
+	queued int
+
+	configs       map[string]TenantConfig
+	defaultConfig TenantConfig
+
+	// wake is closed to wake up all waiting workers when a request may be dispatched
+	wake chan struct{}
+	// waiting maps indexes of workers waiting for requests to the workers. A worker removed by
+	// Pool.Resize may still be leaving next when a new worker takes its index, so workers
+	// delete only their own entries.
+	waiting map[int]*poolWorker
+
+	// sticky enables routing requests to the worker chosen by request affinity,
+	// size is the number of workers affinity is distributed between
+	sticky bool
+	size   int
+}
+
+type tenantQueue struct {
+	name    string
+	cfg     TenantConfig
+	queue   []*workerRequest
+	running int
+	pass    float64
+	stats   TenantStats
+}
+
+func newScheduler(configs map[string]TenantConfig, defaultConfig TenantConfig, sticky bool) *scheduler {
+	return &scheduler{
+		tenants:       make(map[string]*tenantQueue),
+		retained:      make(map[string]TenantStats),
+		configs:       configs,
+		defaultConfig: defaultConfig,
+		wake:          make(chan struct{}),
+		waiting:       make(map[int]*poolWorker),
+		sticky:        sticky,
+	}
+}
+
+// setSize updates the number of workers used for sticky routing.
+func (s *scheduler) setSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	s.notify()
+}
+
+func (s *scheduler) push(req *workerRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tq := s.tenantLocked(req.tenant)
+	if len(tq.queue) == 0 && tq.running == 0 && tq.pass < s.vtime {
+		tq.pass = s.vtime
+	}
+	tq.queue = append(tq.queue, req)
+	s.queued++
+	s.notify()
+}
+
+// remove deletes the request from the queue. It returns false if the request
+// has already been given to a worker.
+func (s *scheduler) remove(req *workerRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tq := s.tenants[req.tenant]
+	if tq == nil {
+		return false
+	}
+	for i := range tq.queue {
+		if tq.queue[i] == req {
+			tq.queue = append(tq.queue[:i], tq.queue[i+1:]...)
+			s.queued--
+			s.removeIfIdleLocked(tq)
+			return true
+		}
+	}
+	return false
+}
+
+// next blocks until a request can be dispatched to the worker or either done or the worker stop is closed.
+// It returns nil request and true if the idle worker is woken up with its wake channel.
+func (s *scheduler) next(done <-chan struct{}, w *poolWorker) (*workerRequest, bool) {
+	defer func() {
+		s.mu.Lock()
+		s.stopWaitingLocked(w)
+		s.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-done:
+			return nil, false
+		case <-w.stop:
+			return nil, false
+		default:
+		}
+
+		s.mu.Lock()
+		req := s.popLocked(w)
+		if req != nil {
+			s.mu.Unlock()
+			return req, true
+		}
+		s.waiting[w.index] = w
+		notified := s.wake
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+			return nil, false
+		case <-w.stop:
+			return nil, false
+		case <-w.wake:
+			return nil, true
+		case <-notified:
+		}
+	}
+}
+
+// stopWaitingLocked removes the worker from waiting ones, unless its index is taken by another worker.
+func (s *scheduler) stopWaitingLocked(w *poolWorker) {
+	if s.waiting[w.index] == w {
+		delete(s.waiting, w.index)
+	}
+}
+
+func (s *scheduler) popLocked(w *poolWorker) *workerRequest {
+	var (
+		picked    *tenantQueue
+		pickedIdx int
+	)
+	for _, tq := range s.tenants {
+		if !tq.eligible() || (picked != nil && !tq.before(picked)) {
+			continue
+		}
+		if i := s.takeableLocked(tq, w.index); i >= 0 {
+			picked, pickedIdx = tq, i
+		}
+	}
+	if picked == nil {
+		return nil
+	}
+
+	req := picked.queue[pickedIdx]
+	picked.queue = append(picked.queue[:pickedIdx], picked.queue[pickedIdx+1:]...)
+	picked.running++
+	s.queued--
+	s.vtime = picked.pass
+	picked.pass += float64(req.cost()) / float64(picked.weight())
+
+	// the worker is not waiting anymore, so other workers
+	// may take remaining requests, including ones preferring it
+	s.stopWaitingLocked(w)
+	if s.queued > 0 {
+		s.notify()
+	}
+	return req
+}
+
+// takeableLocked returns index of the first request in the tenant queue the worker may take or -1.
+// With sticky routing a request is given to its preferred worker, unless that worker is busy.
+func (s *scheduler) takeableLocked(tq *tenantQueue, worker int) int {
+	if !s.sticky || s.size == 0 {
+		return 0
+	}
+	for i, req := range tq.queue {
+		preferred := int(req.affinity % uint64(s.size))
+		if preferred == worker || s.waiting[preferred] == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// finish releases the tenant concurrency slot taken by the request. completed and failed tell
+// if the caller request is completed by it and has failed, see Pool.complete.
+func (s *scheduler) finish(req *workerRequest, err error, completed, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tq := s.tenants[req.tenant]
+	tq.running--
+	if completed {
+		tq.stats.count(failed)
+	}
+	if err == nil {
+		tq.stats.Characters += uint64(req.characters)
+	}
+	s.removeIfIdleLocked(tq)
+	if s.queued > 0 {
+		s.notify()
+	}
+}
+
+// countRequest counts the caller request of the tenant completed without a worker,
+// when the last shard of its batch is withdrawn from the queue.
+func (s *scheduler) countRequest(tenant string, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tq := s.tenants[tenant]; tq != nil {
+		tq.stats.count(failed)
+		return
+	}
+	if stats, ok := s.retained[tenant]; ok {
+		stats.count(failed)
+		s.retained[tenant] = stats
+	}
+}
+
+func (s *scheduler) queueLength() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queued
+}
+
+func (s *scheduler) tenantsStats() map[string]TenantStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]TenantStats, len(s.tenants)+len(s.retained))
+	for tenant, tenantStats := range s.retained {
+		stats[tenant] = tenantStats
+	}
+	for tenant, tq := range s.tenants {
+		tenantStats := tq.stats
+		tenantStats.Queued = len(tq.queue)
+		tenantStats.Running = tq.running
+		stats[tenant] = tenantStats
+	}
+	return stats
+}
+
+func (s *scheduler) tenantLocked(tenant string) *tenantQueue {
+	tq, ok := s.tenants[tenant]
+	if !ok {
+		cfg, ok := s.configs[tenant]
+		if !ok {
+			cfg = s.defaultConfig
+		}
+		tq = &tenantQueue{name: tenant, cfg: cfg, pass: s.vtime, stats: s.retained[tenant]}
+		delete(s.retained, tenant)
+		s.tenants[tenant] = tq
+	}
+	return tq
+}
+
+// removeIfIdleLocked removes the queue of the tenant which has no queued and running requests.
+// Statistics are retained only for configured tenants and the default one, so the scheduler
+// does not grow with the number of tenant keys.
+func (s *scheduler) removeIfIdleLocked(tq *tenantQueue) {
+	if len(tq.queue) != 0 || tq.running != 0 {
+		return
+	}
+	delete(s.tenants, tq.name)
+	if _, configured := s.configs[tq.name]; configured || tq.name == "" {
+		s.retained[tq.name] = tq.stats
+	}
+
+	// the least pass of remaining tenants is the virtual time new tenants start from
+	first := true
+	for _, other := range s.tenants {
+		if first || other.pass < s.vtime {
+			s.vtime, first = other.pass, false
+		}
+	}
+}
+
+// notify wakes up all waiting workers. It must be called with s.mu held.
+func (s *scheduler) notify() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+func (s *TenantStats) count(failed bool) {
+	s.Requests++
+	if failed {
+		s.Errors++
+	}
+}
+
+func (tq *tenantQueue) eligible() bool {
+	if len(tq.queue) == 0 {
+		return false
+	}
+	return tq.cfg.MaxConcurrency == 0 || uint(tq.running) < tq.cfg.MaxConcurrency
+}
+
+// before reports if the tenant goes before the other one: it has the least pass,
+// and ties are broken by tenant keys to dispatch requests in a stable order.
+func (tq *tenantQueue) before(other *tenantQueue) bool {
+	if tq.pass != other.pass {
+		return tq.pass < other.pass
+	}
+	return tq.name < other.name
+}
+
+func (tq *tenantQueue) weight() uint {
+	if tq.cfg.Weight == 0 {
+		return 1
+	}
+	return tq.cfg.Weight
+}
@@ -0,0 +1,109 @@
+package gobergamot
+
+import (
+	"strings"
+	"sync"
+)
+
+// shardBatch tracks shards of a caller batch, so the batch is counted in PoolStats as a single request.
+type shardBatch struct {
+	mu        sync.Mutex
+	remaining int
+	// processed is set if any shard has been processed by a worker, failed if any has failed
+	processed bool
+	failed    bool
+}
+
+func newShardBatch(shards int) *shardBatch {
+	return &shardBatch{remaining: shards}
+}
+
+// done marks a shard done, either processed by a worker or withdrawn from the queue.
+// It reports if the batch is completed, i.e. all its shards are done and some were processed,
+// and if any of its shards has failed.
+func (b *shardBatch) done(processed, failed bool) (completed, anyFailed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining--
+	b.processed = b.processed || processed
+	b.failed = b.failed || failed
+	return b.remaining == 0 && b.processed, b.failed
+}
+
+// shard splits requests into contiguous parts with similar number of words,
+// one part per idle worker. Every part gets at least PoolConfig.ShardWords words,
+// so requests are not split if they have less than twice as many.
+func (p *Pool) shard(requests []TranslationRequest) [][]TranslationRequest {
+	if p.cfg.ShardWords == 0 || len(requests) < 2 {
+		return [][]TranslationRequest{requests}
+	}
+
+	words := make([]int, len(requests))
+	var totalWords int
+	for i := range requests {
+		words[i] = len(strings.Fields(requests[i].Text))
+		totalWords += words[i]
+	}
+
+	n := min(totalWords/int(p.cfg.ShardWords), p.idleWorkers(), len(requests))
+	if n < 2 {
+		return [][]TranslationRequest{requests}
+	}
+	return splitByWords(requests, words, totalWords, n, int(p.cfg.ShardWords))
+}
+
+// wordsRange is a contiguous part of requests with its number of words.
+type wordsRange struct {
+	start, end, words int
+}
+
+// splitByWords greedily fills each of n parts until it reaches its share of remaining words.
+// Greedy parts may be short of minWords, e.g. the last one after a long request,
+// so such parts are merged into their neighbours and fewer than n parts may be returned.
+func splitByWords(requests []TranslationRequest, words []int, totalWords, n, minWords int) [][]TranslationRequest {
+	parts := make([]wordsRange, 0, n)
+	start, shardWords := 0, 0
+	for i := range requests {
+		shardWords += words[i]
+		left := n - len(parts)
+		// the shard is closed when it has its share of words,
+		// but each of remaining shards must get at least one request
+		full := shardWords*left >= totalWords
+		mustClose := len(requests)-(i+1) == left-1
+		if left > 1 && (full || mustClose) {
+			parts = append(parts, wordsRange{start: start, end: i + 1, words: shardWords})
+			totalWords -= shardWords
+			start, shardWords = i+1, 0
+		}
+	}
+	parts = append(parts, wordsRange{start: start, end: len(requests), words: shardWords})
+
+	merged := parts[:1]
+	for _, part := range parts[1:] {
+		last := &merged[len(merged)-1]
+		if last.words < minWords || part.words < minWords {
+			last.end = part.end
+			last.words += part.words
+			continue
+		}
+		merged = append(merged, part)
+	}
+	shards := make([][]TranslationRequest, len(merged))
+	for i, part := range merged {
+		shards[i] = requests[part.start:part.end]
+	}
+	return shards
+}
+
+func (p *Pool) idleWorkers() int {
+	p.workersMu.RLock()
+	defer p.workersMu.RUnlock()
+
+	var idle int
+	for _, w := range p.workers {
+		if !w.busy.Load() {
+			idle++
+		}
+	}
+	return idle
+}
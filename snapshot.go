@@ -0,0 +1,105 @@
+package gobergamot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ErrSnapshotUnsupported is returned if Config.SnapshotMemory is enabled but the module state
+// can not be restored from a memory snapshot, e.g. since it holds emval handles.
+var ErrSnapshotUnsupported = errors.New("module does not support memory snapshots")
+
+// memorySnapshot is a copy of the module state taken after Translator initialization:
+// the linear memory and all mutable globals, which the Engine exports, see wasm.ExportMutableGlobals.
+//
+// Embind wrappers of TranslationModel and BlockingService refer to objects by their addresses
+// in the linear memory, so the snapshot is restored into the same module instance only:
+// wrappers are bound to the embind engine of their instance, which can not create wrappers
+// of objects it has not constructed, so they can not be bound to objects copied into another instance.
+type memorySnapshot struct {
+	memory []byte
+	// globals are values of mutable globals in order of Engine globals
+	globals []uint64
+}
+
+// takeSnapshot copies the module memory and globals. It must be called when no module function is running.
+func takeSnapshot(mod api.Module, globals []string) (*memorySnapshot, error) {
+	mem := mod.Memory()
+	buf, ok := mem.Read(0, mem.Size())
+	if !ok {
+		return nil, errors.New("failed to read module memory")
+	}
+	snapshot := &memorySnapshot{memory: bytes.Clone(buf), globals: make([]uint64, len(globals))}
+	for i, name := range globals {
+		global, ok := mod.ExportedGlobal(name).(api.MutableGlobal)
+		if !ok {
+			return nil, fmt.Errorf("mutable global %q is not exported", name)
+		}
+		snapshot.globals[i] = global.Get()
+	}
+	return snapshot, nil
+}
+
+// restore returns the module memory and globals to the snapshot state. Memory grown after
+// the snapshot is left as is: heap bounds are kept in the memory, so the module
+// considers grown pages free.
+func (s *memorySnapshot) restore(mod api.Module, globals []string) error {
+	if !mod.Memory().Write(0, s.memory) {
+		return errors.New("failed to write module memory")
+	}
+	for i, name := range globals {
+		global, ok := mod.ExportedGlobal(name).(api.MutableGlobal)
+		if !ok {
+			return fmt.Errorf("mutable global %q is not exported", name)
+		}
+		global.Set(s.globals[i])
+	}
+	return nil
+}
+
+// takeSnapshot takes the memory snapshot of the initialized Translator.
+// Embind keeps emval handles outside the module, and handles released after the snapshot
+// would be dead once it is restored, so the module must not hold any.
+func (t *Translator) takeSnapshot() error {
+	if handles := t.embindEngine.CountEmvalHandles(); handles != 0 {
+		return fmt.Errorf("%w: module holds %d emval handles", ErrSnapshotUnsupported, handles)
+	}
+	snapshot, err := takeSnapshot(t.module, t.engine.globals)
+	if err != nil {
+		return err
+	}
+	t.snapshot = snapshot
+	return nil
+}
+
+// restoreSnapshot returns the Translator to the state right after initialization,
+// so it is not poisoned anymore.
+func (t *Translator) restoreSnapshot(ctx context.Context) error {
+	if t.snapshot == nil {
+		return errors.New("translator has no memory snapshot")
+	}
+	// objects created after the snapshot are gone along with the memory
+	t.objects.discardCall()
+	if err := t.snapshot.restore(t.module, t.engine.globals); err != nil {
+		return fmt.Errorf("failed to restore memory snapshot: %w", err)
+	}
+	// objects of the instance were created before the snapshot, so their wrappers are valid again
+	// unless they have been deleted since
+	for _, object := range t.objects.instance {
+		if object.isDeleted(ctx) {
+			return fmt.Errorf("failed to restore memory snapshot: %s has been deleted", object.class)
+		}
+	}
+	if handles := t.embindEngine.CountEmvalHandles(); handles != 0 {
+		// the restored memory does not refer to handles created after the snapshot
+		t.logger.Warn("emval handles created after memory snapshot are lost", slog.Int("handles", handles))
+	}
+	t.poison = nil
+	t.setState(TranslatorReady)
+	return nil
+}
@@ -0,0 +1,83 @@
+package gobergamot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrClosed is matched by errors of Translator and Pool calls after Close.
+var ErrClosed = errors.New("closed")
+
+// errTranslatorClosed is returned by Translator calls after Close.
+var errTranslatorClosed = fmt.Errorf("translator %w", ErrClosed)
+
+// TranslatorState is a lifecycle state of Translator.
+//
+// Translator starts initializing and becomes ready when the model is loaded. A trap makes it poisoned,
+// and restoring its memory snapshot makes it ready again. A translation aborted with Config.WASMUseContext
+// makes it initializing until the module is restored, or poisoned if the restore fails.
+// Close makes it closed from any state, which is final.
+type TranslatorState int32
+
+const (
+	// TranslatorInitializing is the state of Translator loading the model into a new module instance,
+	// which happens on creation and on restore after a translation aborted with Config.WASMUseContext.
+	TranslatorInitializing TranslatorState = iota
+	// TranslatorReady is the state of Translator accepting translations.
+	TranslatorReady
+	// TranslatorPoisoned is the state of Translator rejecting translations with ErrTranslatorPoisoned,
+	// see Translator.Poisoned.
+	TranslatorPoisoned
+	// TranslatorClosed is the state of Translator rejecting all calls with ErrClosed.
+	TranslatorClosed
+)
+
+func (s TranslatorState) String() string {
+	switch s {
+	case TranslatorInitializing:
+		return "initializing"
+	case TranslatorReady:
+		return "ready"
+	case TranslatorPoisoned:
+		return "poisoned"
+	case TranslatorClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("TranslatorState(%d)", int32(s))
+}
+
+// State returns the current state of the Translator. It does not wait for a running call,
+// so the state may change right after it returns.
+func (t *Translator) State() TranslatorState {
+	return TranslatorState(t.state.Load())
+}
+
+// setState must be called with t.mu held.
+func (t *Translator) setState(state TranslatorState) {
+	t.state.Store(int32(state))
+}
+
+// checkUsable returns the error Translator calls fail with in the current state, if any.
+// It must be called with t.mu held.
+func (t *Translator) checkUsable() error {
+	switch {
+	case t.State() == TranslatorClosed:
+		return errTranslatorClosed
+	case t.engine.closed.Load():
+		return ErrEngineClosed
+	case t.State() == TranslatorPoisoned:
+		return fmt.Errorf("%w: %w", ErrTranslatorPoisoned, t.poison)
+	}
+	return nil
+}
+
+// resetToSnapshot restores the memory snapshot of the Translator, which makes it ready again.
+func (t *Translator) resetToSnapshot(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.State() == TranslatorClosed {
+		return errTranslatorClosed
+	}
+	return t.restoreSnapshot(ctx)
+}
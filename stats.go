@@ -0,0 +1,120 @@
+package gobergamot
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolStats is a snapshot of Pool state and cumulative counters since Pool creation.
+type PoolStats struct {
+	// BusyWorkers is a number of workers translating at the moment of snapshot
+	BusyWorkers int
+	// IdleWorkers is a number of workers waiting for requests
+	IdleWorkers int
+	// QueueLength is a number of callers waiting for a free worker
+	QueueLength int
+	// Workers contains per-worker statistics in order of workers creation
+	Workers []WorkerStats
+
+	// Requests is a number of caller requests processed by workers.
+	// A batch split with PoolConfig.ShardWords is counted once, when all its shards are done.
+	Requests uint64
+	// Shards is a number of parts of requests processed by workers. It equals Requests
+	// unless batches are split with PoolConfig.ShardWords.
+	Shards uint64
+	// Sentences is a number of source sentences translated, as split by Bergamot
+	Sentences uint64
+	// Characters is a number of source characters (runes) translated
+	Characters uint64
+	// Errors is a number of caller requests which failed in a worker,
+	// a batch is counted once if any of its shards has failed
+	Errors uint64
+	// Recycled is a number of translators replaced according to PoolConfig.Recycle
+	Recycled uint64
+
+	// QueueWait describes time spent by requests waiting for a free worker
+	QueueWait LatencyStats
+	// Translation describes time spent by workers translating requests
+	Translation LatencyStats
+
+	// Tenants contains statistics of tenants which have sent requests to the Pool.
+	// Tenants missing in PoolConfig.Tenants, except the default one, are present only while
+	// they have queued or running requests, so the statistics do not grow with the number of tenant keys.
+	Tenants map[string]TenantStats
+}
+
+// WorkerStats contains statistics of a single Pool worker.
+type WorkerStats struct {
+	// Busy defines if the worker is translating at the moment of snapshot
+	Busy bool
+	// Requests is a number of requests processed by the worker, shards of a batch are counted separately
+	Requests uint64
+	// MemoryBytes is a size of the worker translator WASM linear memory
+	MemoryBytes uint64
+}
+
+// LatencyStats contains percentiles of recently observed durations.
+// Percentiles are calculated over the last latencyWindowSize observations.
+type LatencyStats struct {
+	P50, P90, P99, Max time.Duration
+}
+
+type poolCounters struct {
+	requests   atomic.Uint64
+	shards     atomic.Uint64
+	sentences  atomic.Uint64
+	characters atomic.Uint64
+	errors     atomic.Uint64
+	recycled   atomic.Uint64
+
+	queueWait   latencyWindow
+	translation latencyWindow
+}
+
+const latencyWindowSize = 1024
+
+// latencyWindow keeps last latencyWindowSize durations in a ring buffer.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+func (w *latencyWindow) stats() LatencyStats {
+	w.mu.Lock()
+	samples := slices.Clone(w.samples)
+	w.mu.Unlock()
+
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+	slices.Sort(samples)
+	return LatencyStats{
+		P50: percentile(samples, 50),
+		P90: percentile(samples, 90),
+		P99: percentile(samples, 99),
+		Max: samples[len(samples)-1],
+	}
+}
+
+// percentile returns nearest-rank percentile of sorted samples.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -6,17 +6,27 @@ import (
 	"time"
 
 	"github.com/xxnuo/gobergamot"
+	"github.com/xxnuo/gobergamot/internal/wasm"
 )
 
 func BenchmarkSingleSentence(b *testing.B) {
+	benchmarkSingleSentence(b, gobergamot.Config{})
+}
+
+func BenchmarkSingleSentenceNativeGEMM(b *testing.B) {
+	benchmarkSingleSentence(b, gobergamot.Config{
+		CompileConfig: wasm.CompileConfig{GemmBackend: gobergamot.NativeGemm()},
+	})
+}
+
+func benchmarkSingleSentence(b *testing.B, cfg gobergamot.Config) {
 	b.StopTimer()
 
 	startCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	translator, err := gobergamot.New(startCtx, gobergamot.Config{
-		FilesBundle: testBundle(nil),
-	})
+	cfg.FilesBundle = testBundle(nil)
+	translator, err := gobergamot.New(startCtx, cfg)
 	if err != nil {
 		b.Fatalf("failed to create translator: %v", err)
 	}
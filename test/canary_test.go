@@ -0,0 +1,132 @@
+package gobergamot_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/xxnuo/gobergamot"
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+func TestCanaryRouter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	newPool := func() *gobergamot.Pool {
+		pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+			Config: gobergamot.Config{
+				FilesBundle: testBundle(t),
+			},
+			PoolSize: 1,
+		})
+		if err != nil {
+			t.Fatalf("NewPool returned error %v", err)
+		}
+		t.Cleanup(func() {
+			if err := pool.Close(ctx); err != nil {
+				t.Fatalf("failed to close pool: %v", err)
+			}
+		})
+		return pool
+	}
+	stable, canary := newPool(), newPool()
+
+	if _, err := gobergamot.NewCanaryRouter(stable, canary, gobergamot.CanaryConfig{Percent: 101}); err == nil {
+		t.Fatal("expected error for invalid percent")
+	}
+
+	router, err := gobergamot.NewCanaryRouter(stable, canary, gobergamot.CanaryConfig{
+		Percent: 50,
+		Shadow:  true,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewCanaryRouter returned error %v", err)
+	}
+
+	const requestsCount = 20
+	for range requestsCount {
+		output, err := router.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+		if err != nil {
+			t.Fatalf("Translate returned error %v", err)
+		}
+		if output != helloWorldTranslation {
+			t.Errorf("unexpected output %s", output)
+		}
+	}
+
+	stats := router.Stats()
+	if stats.Stable.Requests+stats.Canary.Requests != requestsCount {
+		t.Errorf("unexpected arms stats %+v", stats)
+	}
+
+	// shadow translations are done in background
+	for router.Stats().Shadow.Requests < stats.Stable.Requests {
+		select {
+		case <-ctx.Done():
+			t.Fatal("context timeout waiting for shadow translations")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if stats := router.Stats(); stats.Shadow.Mismatches != 0 || stats.Shadow.Errors != 0 {
+		t.Errorf("unexpected shadow stats %+v", stats.Shadow)
+	}
+
+	if err := router.Close(ctx); err != nil {
+		t.Fatalf("failed to close router: %v", err)
+	}
+	if _, err := router.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"}); !errors.Is(err, gobergamot.ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestCanaryRouter_MaxShadows(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	newPool := func(cfg gobergamot.Config) *gobergamot.Pool {
+		cfg.FilesBundle = testBundle(t)
+		pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{Config: cfg, PoolSize: 1})
+		if err != nil {
+			t.Fatalf("NewPool returned error %v", err)
+		}
+		t.Cleanup(func() {
+			if err := pool.Close(ctx); err != nil {
+				t.Fatalf("failed to close pool: %v", err)
+			}
+		})
+		return pool
+	}
+	// the canary pool translates nothing until the gate is closed
+	gate := make(chan struct{})
+	stable := newPool(gobergamot.Config{})
+	canary := newPool(gobergamot.Config{
+		CompileConfig: wasm.CompileConfig{GemmBackend: blockingGemm{GemmBackend: gobergamot.NativeGemm(), gate: gate}},
+	})
+
+	router, err := gobergamot.NewCanaryRouter(stable, canary, gobergamot.CanaryConfig{Shadow: true, MaxShadows: 1})
+	if err != nil {
+		t.Fatalf("NewCanaryRouter returned error %v", err)
+	}
+	for range 3 {
+		if _, err := router.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"}); err != nil {
+			t.Fatalf("Translate returned error %v", err)
+		}
+	}
+	if dropped := router.Stats().Shadow.Dropped; dropped != 2 {
+		t.Errorf("expected 2 shadows to be dropped while the first one is running, got %d", dropped)
+	}
+
+	close(gate)
+	if err := router.Close(ctx); err != nil {
+		t.Fatalf("failed to close router: %v", err)
+	}
+	// Close waits for the running shadow
+	if stats := router.Stats().Shadow; stats.Requests != 1 || stats.Errors != 0 || stats.Mismatches != 0 {
+		t.Errorf("unexpected shadow stats %+v", stats)
+	}
+}
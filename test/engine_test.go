@@ -0,0 +1,198 @@
+package gobergamot_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xxnuo/gobergamot"
+)
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+
+	engine, err := gobergamot.NewEngine(ctx, gobergamot.EngineConfig{})
+	if err != nil {
+		t.Fatalf("failed to create engine: %v", err)
+	}
+
+	translators := make([]*gobergamot.Translator, 2)
+	for i := range translators {
+		translators[i], err = engine.NewTranslator(ctx, gobergamot.Config{FilesBundle: testBundle(t)})
+		if err != nil {
+			t.Fatalf("failed to create translator %d: %v", i, err)
+		}
+	}
+
+	const text, wantedOutput = "Hello, World!", "Здравствуйте, Мир!"
+	for i, translator := range translators {
+		output, err := translator.Translate(ctx, gobergamot.TranslationRequest{Text: text})
+		if err != nil {
+			t.Fatalf("translator %d failed to translate: %v", i, err)
+		}
+		if output != wantedOutput {
+			t.Errorf("translator %d\nexpected: %s\ngot: %s", i, wantedOutput, output)
+		}
+	}
+
+	// closing a translator must not affect others
+	if err := translators[0].Close(ctx); err != nil {
+		t.Fatalf("failed to close translator: %v", err)
+	}
+	if _, err := translators[1].Translate(ctx, gobergamot.TranslationRequest{Text: text}); err != nil {
+		t.Fatalf("failed to translate after closing another translator: %v", err)
+	}
+
+	if err := engine.Close(ctx); err != nil {
+		t.Fatalf("failed to close engine: %v", err)
+	}
+	if _, err := translators[1].Translate(ctx, gobergamot.TranslationRequest{Text: text}); !errors.Is(err, gobergamot.ErrEngineClosed) {
+		t.Errorf("expected translation to fail with closed engine, got %v", err)
+	}
+	if err := translators[1].Close(ctx); err != nil {
+		t.Errorf("failed to close translator of closed engine: %v", err)
+	}
+	if _, err := engine.NewTranslator(ctx, gobergamot.Config{FilesBundle: testBundle(t)}); !errors.Is(err, gobergamot.ErrEngineClosed) {
+		t.Errorf("expected translator creation to fail with closed engine, got %v", err)
+	}
+}
+
+func TestEngine_ExecutionMode(t *testing.T) {
+	ctx := context.Background()
+	// compiler can be forced only where it is supported
+	wantAuto, wantCompiler, wantCompilerErr := gobergamot.ExecutionInterpreter, gobergamot.ExecutionCompiler, error(nil)
+	if gobergamot.CompilerSupported() {
+		wantAuto = gobergamot.ExecutionCompiler
+	} else {
+		wantCompilerErr = gobergamot.ErrCompilerUnsupported
+	}
+
+	tests := []struct {
+		mode    gobergamot.ExecutionMode
+		want    gobergamot.ExecutionMode
+		wantErr error
+	}{
+		{mode: gobergamot.ExecutionAuto, want: wantAuto},
+		{mode: gobergamot.ExecutionCompiler, want: wantCompiler, wantErr: wantCompilerErr},
+		{mode: gobergamot.ExecutionInterpreter, want: gobergamot.ExecutionInterpreter},
+		{mode: gobergamot.ExecutionMode(10), wantErr: gobergamot.ErrInvalidExecutionMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			engine, err := gobergamot.NewEngine(ctx, gobergamot.EngineConfig{ExecutionMode: tt.mode})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create engine: %v", err)
+			}
+			defer engine.Close(ctx)
+			if got := engine.ExecutionMode(); got != tt.want {
+				t.Errorf("expected %s execution mode, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEngine_IncompatibleWASM(t *testing.T) {
+	ctx := context.Background()
+
+	// empty module, which exports nothing
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	_, err := gobergamot.NewEngine(ctx, gobergamot.EngineConfig{WASM: module, CacheDir: t.TempDir()})
+	if !errors.Is(err, gobergamot.ErrIncompatibleWASM) {
+		t.Fatalf("expected ErrIncompatibleWASM, got %v", err)
+	}
+	var abiErr *gobergamot.WASMABIError
+	if !errors.As(err, &abiErr) {
+		t.Fatalf("expected WASMABIError, got %T", err)
+	}
+	want := gobergamot.ABIMismatch{Kind: "export", Name: "malloc", Reason: "missing"}
+	if !slices.Contains(abiErr.Mismatches, want) {
+		t.Errorf("expected %v among mismatches, got %v", want, abiErr.Mismatches)
+	}
+
+	if _, err := gobergamot.New(ctx, gobergamot.Config{FilesBundle: testBundle(t), WASM: module, CacheDir: t.TempDir()}); !errors.Is(err, gobergamot.ErrIncompatibleWASM) {
+		t.Errorf("expected New to fail with ErrIncompatibleWASM, got %v", err)
+	}
+}
+
+func TestEngine_CustomWASMCacheEntries(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	entries := func() []string {
+		t.Helper()
+		dirEntries, err := os.ReadDir(cacheDir)
+		if err != nil {
+			t.Fatalf("failed to read cache directory: %v", err)
+		}
+		var names []string
+		for _, entry := range dirEntries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+	// modules are incompatible, but the cache entry is created before the check
+	newEngine := func(module []byte) {
+		t.Helper()
+		if _, err := gobergamot.NewEngine(ctx, gobergamot.EngineConfig{WASM: module, CacheDir: cacheDir}); !errors.Is(err, gobergamot.ErrIncompatibleWASM) {
+			t.Fatalf("expected ErrIncompatibleWASM, got %v", err)
+		}
+	}
+	touch := func(name string, age time.Duration) {
+		t.Helper()
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(cacheDir, name), modTime, modTime); err != nil {
+			t.Fatalf("failed to change cache entry time: %v", err)
+		}
+	}
+
+	first := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	// the same module with a custom section "x"
+	second := append(slices.Clone(first), 0x00, 0x03, 0x01, 'x', 0x00)
+
+	newEngine(first)
+	firstEntries := entries()
+	if len(firstEntries) != 1 {
+		t.Fatalf("expected single cache entry, got %v", firstEntries)
+	}
+	touch(firstEntries[0], 31*24*time.Hour)
+	newEngine(second)
+	secondEntries := entries()
+	if len(secondEntries) != 1 || secondEntries[0] == firstEntries[0] {
+		t.Fatalf("expected entry not used for 31 days to be replaced by a new one, got %v", secondEntries)
+	}
+
+	touch(secondEntries[0], 29*24*time.Hour)
+	newEngine(first)
+	if got := entries(); len(got) != 2 || !slices.Contains(got, secondEntries[0]) {
+		t.Errorf("expected entry used 29 days ago to be kept, got %v", got)
+	}
+}
+
+func TestExecutionMode_UnmarshalText(t *testing.T) {
+	for _, mode := range []gobergamot.ExecutionMode{
+		gobergamot.ExecutionAuto, gobergamot.ExecutionCompiler, gobergamot.ExecutionInterpreter,
+	} {
+		text, err := mode.MarshalText()
+		if err != nil {
+			t.Fatalf("failed to marshal %s: %v", mode, err)
+		}
+		var got gobergamot.ExecutionMode
+		if err := got.UnmarshalText(text); err != nil || got != mode {
+			t.Errorf("expected %s unmarshaled from %q, got %s and error %v", mode, text, got, err)
+		}
+	}
+
+	var mode gobergamot.ExecutionMode
+	if err := mode.UnmarshalText([]byte("jit")); !errors.Is(err, gobergamot.ErrInvalidExecutionMode) {
+		t.Errorf("expected invalid execution mode error, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package gobergamot_test
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/xxnuo/gobergamot"
+)
+
+type logRecord struct {
+	level     slog.Level
+	msg       string
+	component string
+	tenant    string
+}
+
+// recordingHandler keeps records with their component and tenant attributes.
+type recordingHandler struct {
+	mu      *sync.Mutex
+	records *[]logRecord
+}
+
+func newRecordingHandler() recordingHandler {
+	return recordingHandler{mu: &sync.Mutex{}, records: &[]logRecord{}}
+}
+
+func (h recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := logRecord{level: r.Level, msg: r.Message}
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "component":
+			rec.component = a.Value.String()
+		case "tenant":
+			rec.tenant = a.Value.String()
+		}
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, rec)
+	return nil
+}
+
+func (h recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h recordingHandler) WithGroup(string) slog.Handler { return h }
+
+func (h recordingHandler) get() []logRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(*h.records)
+}
+
+func TestMarianLogWriter(t *testing.T) {
+	tests := []struct {
+		line string
+		want logRecord
+	}{
+		{
+			line: "[2024-05-01 10:00:00] [memory] Reserving 128 MB, device cpu0\n",
+			want: logRecord{level: slog.LevelInfo, msg: "Reserving 128 MB, device cpu0", component: "memory"},
+		},
+		{
+			line: "[2024-05-01 10:00:00] [warn] Unknown option\n",
+			want: logRecord{level: slog.LevelWarn, msg: "Unknown option"},
+		},
+		{
+			line: "[2024-05-01 10:00:00] Error: Requested shortlist does not exist\r\n",
+			want: logRecord{level: slog.LevelError, msg: "Requested shortlist does not exist"},
+		},
+		{
+			line: "[2024-05-01 10:00:00] [data] Warning: vocabulary is empty\n",
+			want: logRecord{level: slog.LevelWarn, msg: "vocabulary is empty", component: "data"},
+		},
+		{
+			line: "[config] [x] is not a tag\n",
+			want: logRecord{level: slog.LevelInfo, msg: "[x] is not a tag", component: "config"},
+		},
+		{
+			line: "plain line\n",
+			want: logRecord{level: slog.LevelInfo, msg: "plain line"},
+		},
+	}
+	for _, tt := range tests {
+		handler := newRecordingHandler()
+		w := gobergamot.NewMarianLogWriter(slog.New(handler), slog.LevelInfo)
+		if _, err := w.Write([]byte(tt.line)); err != nil {
+			t.Fatalf("failed to write %q: %v", tt.line, err)
+		}
+		if got := handler.get(); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("line %q\nexpected: %+v\ngot: %+v", tt.line, tt.want, got)
+		}
+	}
+}
+
+func TestMarianLogWriter_PartialLines(t *testing.T) {
+	handler := newRecordingHandler()
+	w := gobergamot.NewMarianLogWriter(slog.New(handler), slog.LevelInfo)
+	for _, part := range []string{"[info] fir", "st\n\n[info] sec", "ond\n[info] incomplete"} {
+		if _, err := w.Write([]byte(part)); err != nil {
+			t.Fatalf("failed to write %q: %v", part, err)
+		}
+	}
+	want := []logRecord{{level: slog.LevelInfo, msg: "first"}, {level: slog.LevelInfo, msg: "second"}}
+	if got := handler.get(); !slices.Equal(got, want) {
+		t.Errorf("expected: %+v\ngot: %+v", want, got)
+	}
+}
@@ -278,6 +278,11 @@ func TestTranslatorMemoryLeakCheck(t *testing.T) {
 		initialStats.HeapObjects, finalStats.HeapObjects,
 		finalStats.HeapObjects-initialStats.HeapObjects)
 
+	// 调试构建会检查每次翻译是否遗留了未删除的 embind 对象，它们占用 WASM 内存而不是 Go 堆
+	if leaked := translator.LeakedObjects(); len(leaked) != 0 {
+		t.Errorf("翻译遗留了未删除的 embind 对象: %v", leaked)
+	}
+
 	// 判断是否可能存在内存泄漏
 	// 这里设置一个阈值，如果内存增长超过这个阈值，则认为可能存在内存泄漏
 	const leakThresholdKB = 1000 // 1MB
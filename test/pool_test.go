@@ -3,10 +3,18 @@ package gobergamot_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/tetratelabs/wazero/api"
+
 	"github.com/xxnuo/gobergamot"
 	"github.com/xxnuo/gobergamot/internal/wasm"
 )
@@ -92,3 +100,792 @@ func TestPool(t *testing.T) {
 		}
 	}
 }
+
+func TestPool_Stats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	stats := pool.Stats()
+	if stats.IdleWorkers != 2 || stats.BusyWorkers != 0 || len(stats.Workers) != 2 {
+		t.Fatalf("unexpected initial stats %+v", stats)
+	}
+
+	_, err = pool.TranslateMultiple(ctx,
+		gobergamot.TranslationRequest{Text: "Hello World"},
+		gobergamot.TranslationRequest{Text: "Goodbye World. Hello World."},
+	)
+	if err != nil {
+		t.Fatalf("TranslateMultiple returned error %v", err)
+	}
+
+	stats = pool.Stats()
+	if stats.Requests != 1 {
+		t.Errorf("expected 1 request, got %d", stats.Requests)
+	}
+	if stats.Sentences != 3 {
+		t.Errorf("expected 3 sentences, got %d", stats.Sentences)
+	}
+	if stats.Characters != uint64(len("Hello World")+len("Goodbye World. Hello World.")) {
+		t.Errorf("unexpected characters count %d", stats.Characters)
+	}
+	if stats.Errors != 0 {
+		t.Errorf("expected no errors, got %d", stats.Errors)
+	}
+	if stats.Translation.Max == 0 || stats.Translation.P50 > stats.Translation.Max {
+		t.Errorf("unexpected translation latency %+v", stats.Translation)
+	}
+	if stats.Workers[0].Requests+stats.Workers[1].Requests != 1 {
+		t.Errorf("unexpected workers stats %+v", stats.Workers)
+	}
+}
+
+func TestPool_Shutdown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+
+	const requestsCount = 10
+	errChan := make(chan error, requestsCount)
+	for range requestsCount {
+		go func() {
+			output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+			if err == nil && output != helloWorldTranslation {
+				err = fmt.Errorf("unexpected output %s", output)
+			}
+			errChan <- err
+		}()
+	}
+
+	// letting some requests to be accepted by the pool
+	time.Sleep(10 * time.Millisecond)
+	if err := pool.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shutdown pool: %v", err)
+	}
+
+	for range requestsCount {
+		// accepted requests must be translated, the rest must be rejected
+		if err := <-errChan; err != nil && !errors.Is(err, gobergamot.ErrClosed) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+
+	if _, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"}); !errors.Is(err, gobergamot.ErrClosed) {
+		t.Errorf("expected ErrClosed after shutdown, got %v", err)
+	}
+	if err := pool.Close(ctx); err != nil {
+		t.Errorf("Close after Shutdown returned error %v", err)
+	}
+}
+
+func TestPool_Tenants(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 2,
+		Tenants: map[string]gobergamot.TenantConfig{
+			"bulk": {Weight: 1, MaxConcurrency: 1},
+			"ui":   {Weight: 4},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	requests := map[string]int{"bulk": 6, "ui": 3, "": 1}
+	errChan := make(chan error)
+	for tenant, n := range requests {
+		for range n {
+			go func() {
+				output, err := pool.Translate(gobergamot.WithTenant(ctx, tenant), gobergamot.TranslationRequest{Text: "Hello World"})
+				if err == nil && output != helloWorldTranslation {
+					err = fmt.Errorf("unexpected output %s", output)
+				}
+				errChan <- err
+			}()
+		}
+	}
+	for range 10 {
+		if err := <-errChan; err != nil {
+			t.Errorf("Translate returned error %v", err)
+		}
+	}
+
+	stats := pool.Stats()
+	for tenant, n := range requests {
+		tenantStats := stats.Tenants[tenant]
+		if tenantStats.Requests != uint64(n) || tenantStats.Queued != 0 || tenantStats.Running != 0 {
+			t.Errorf("unexpected tenant %q stats %+v", tenant, tenantStats)
+		}
+	}
+}
+
+// blockingGemm holds matrix multiplication, which is called by translation only, until gate is closed.
+type blockingGemm struct {
+	gobergamot.GemmBackend
+	gate <-chan struct{}
+}
+
+func (g blockingGemm) Int8MultiplyAndAddBias(
+	ctx context.Context, mod api.Module,
+	inputAPrepared uint32, scaleA, zeroPointA float32,
+	inputBPrepared uint32, scaleB, zeroPointB float32,
+	inputBiasPrepared uint32, unquantMultiplier float32,
+	rowsA, width, colsB uint32, output uint32,
+) error {
+	<-g.gate
+	return g.GemmBackend.Int8MultiplyAndAddBias(ctx, mod,
+		inputAPrepared, scaleA, zeroPointA,
+		inputBPrepared, scaleB, zeroPointB,
+		inputBiasPrepared, unquantMultiplier,
+		rowsA, width, colsB, output,
+	)
+}
+
+// waitStats polls the Pool statistics until cond is met.
+func waitStats(t *testing.T, pool *gobergamot.Pool, cond func(gobergamot.PoolStats) bool) gobergamot.PoolStats {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		stats := pool.Stats()
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for pool stats, last %+v", stats)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPool_TenantScheduling(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	tenants := map[string]gobergamot.TenantConfig{
+		"light": {Weight: 1, MaxConcurrency: 1},
+		"heavy": {Weight: 3},
+	}
+	// newBlockedPool creates a Pool which workers translate nothing until the returned gate is closed
+	newBlockedPool := func(t *testing.T, size uint, logger *slog.Logger) (*gobergamot.Pool, chan struct{}) {
+		gate := make(chan struct{})
+		pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+			Config: gobergamot.Config{
+				CompileConfig: wasm.CompileConfig{GemmBackend: blockingGemm{GemmBackend: gobergamot.NativeGemm(), gate: gate}},
+				FilesBundle:   testBundle(t),
+				Logger:        logger,
+			},
+			PoolSize: size,
+			Tenants:  tenants,
+		})
+		if err != nil {
+			t.Fatalf("NewPool returned error %v", err)
+		}
+		t.Cleanup(func() {
+			select {
+			case <-gate:
+			default:
+				close(gate)
+			}
+			if err := pool.Close(ctx); err != nil {
+				t.Fatalf("failed to close pool: %v", err)
+			}
+		})
+		return pool, gate
+	}
+	translate := func(pool *gobergamot.Pool, tenant string, errChan chan<- error) {
+		go func() {
+			output, err := pool.Translate(gobergamot.WithTenant(ctx, tenant), gobergamot.TranslationRequest{Text: "Hello World"})
+			if err == nil && output != helloWorldTranslation {
+				err = fmt.Errorf("unexpected output %s", output)
+			}
+			errChan <- err
+		}()
+	}
+	wait := func(t *testing.T, errChan <-chan error, n int) {
+		for range n {
+			if err := <-errChan; err != nil {
+				t.Errorf("Translate returned error %v", err)
+			}
+		}
+	}
+
+	t.Run("weighted shares", func(t *testing.T) {
+		handler := newRecordingHandler()
+		pool, gate := newBlockedPool(t, 1, slog.New(handler))
+		errChan := make(chan error)
+
+		// the only worker is held by a request of the default tenant while others are queued
+		translate(pool, "", errChan)
+		waitStats(t, pool, func(stats gobergamot.PoolStats) bool { return stats.BusyWorkers == 1 })
+		for i := range 8 {
+			translate(pool, []string{"light", "heavy"}[i%2], errChan)
+			waitStats(t, pool, func(stats gobergamot.PoolStats) bool { return stats.QueueLength == i+1 })
+		}
+		close(gate)
+		wait(t, errChan, 9)
+
+		var dispatched []string
+		for _, rec := range handler.get() {
+			if rec.msg == "request dispatched" {
+				dispatched = append(dispatched, rec.tenant)
+			}
+		}
+		// requests cost the same, so heavy tenant passes light one three times as slow
+		expected := []string{"", "heavy", "light", "heavy", "heavy", "heavy", "light", "light", "light"}
+		if !slices.Equal(dispatched, expected) {
+			t.Errorf("expected dispatch order %q, got %q", expected, dispatched)
+		}
+	})
+
+	t.Run("max concurrency", func(t *testing.T) {
+		pool, gate := newBlockedPool(t, 2, nil)
+		errChan := make(chan error)
+
+		for range 3 {
+			translate(pool, "light", errChan)
+		}
+		stats := waitStats(t, pool, func(stats gobergamot.PoolStats) bool {
+			return stats.Tenants["light"].Queued == 2
+		})
+		if stats.Tenants["light"].Running != 1 || stats.IdleWorkers != 1 {
+			t.Errorf("expected light tenant to run on a single worker, got %+v", stats)
+		}
+
+		// the idle worker is left to other tenants
+		translate(pool, "heavy", errChan)
+		stats = waitStats(t, pool, func(stats gobergamot.PoolStats) bool { return stats.BusyWorkers == 2 })
+		if stats.Tenants["light"].Running != 1 || stats.Tenants["heavy"].Running != 1 {
+			t.Errorf("expected light and heavy tenants to run a request each, got %+v", stats.Tenants)
+		}
+		close(gate)
+		wait(t, errChan, 4)
+
+		// queues of idle tenants are removed, statistics are kept only for configured tenants
+		translate(pool, "ephemeral", errChan)
+		wait(t, errChan, 1)
+		stats = pool.Stats()
+		if _, ok := stats.Tenants["ephemeral"]; ok {
+			t.Errorf("expected idle unconfigured tenant to be removed, got %+v", stats.Tenants)
+		}
+		if light := stats.Tenants["light"]; light.Requests != 3 || light.Queued != 0 || light.Running != 0 {
+			t.Errorf("unexpected light tenant stats %+v", light)
+		}
+	})
+}
+
+func TestPool_TranslateMultipleSharded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize:   3,
+		ShardWords: 4,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	requests := make([]gobergamot.TranslationRequest, 30)
+	expected := make([]string, len(requests))
+	for i := range requests {
+		if i%3 == 0 {
+			requests[i].Text, expected[i] = "Goodbye World", goodbyeWorldTranslation
+		} else {
+			requests[i].Text, expected[i] = "Hello World", helloWorldTranslation
+		}
+	}
+
+	outputs, err := pool.TranslateMultiple(ctx, requests...)
+	if err != nil {
+		t.Fatalf("TranslateMultiple returned error %v", err)
+	}
+	if len(outputs) != len(expected) {
+		t.Fatalf("expected %d outputs, got %d", len(expected), len(outputs))
+	}
+	for i := range outputs {
+		if outputs[i] != expected[i] {
+			t.Errorf("output %d: expected %s, got %s", i, expected[i], outputs[i])
+		}
+	}
+	stats := pool.Stats()
+	if stats.Shards < 2 {
+		t.Errorf("expected batch to be split into shards, got %d shards", stats.Shards)
+	}
+	if stats.Requests != 1 || stats.Errors != 0 {
+		t.Errorf("expected batch to be counted as a single request, got %d requests and %d errors", stats.Requests, stats.Errors)
+	}
+}
+
+func TestPool_TranslateMultipleShardWords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize:   2,
+		ShardWords: 5,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	// a greedy split would leave the second request alone in a shard of 1 word
+	requests := []gobergamot.TranslationRequest{
+		{Text: strings.TrimSpace(strings.Repeat("Hello World ", 4)) + " Hello"},
+		{Text: "Hello"},
+	}
+	outputs, err := pool.TranslateMultiple(ctx, requests...)
+	if err != nil {
+		t.Fatalf("TranslateMultiple returned error %v", err)
+	}
+	if len(outputs) != len(requests) {
+		t.Fatalf("expected %d outputs, got %d", len(requests), len(outputs))
+	}
+	if stats := pool.Stats(); stats.Shards != 1 {
+		t.Errorf("expected batch of 10 words not to be split into shards shorter than 5 words, got %d shards", stats.Shards)
+	}
+}
+
+func TestPool_Warmup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 2,
+		Warmup:   true,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	if err := pool.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady returned error %v", err)
+	}
+	select {
+	case <-pool.Ready():
+	default:
+		t.Fatal("Ready channel is not closed after WaitReady")
+	}
+
+	output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+	if err != nil {
+		t.Fatalf("Translate returned error %v", err)
+	}
+	if output != helloWorldTranslation {
+		t.Errorf("unexpected output %s", output)
+	}
+}
+
+func TestPool_Resize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	for _, size := range []uint{3, 2, 1} {
+		if err := pool.Resize(ctx, size); err != nil {
+			t.Fatalf("Resize(%d) returned error %v", size, err)
+		}
+		if got := pool.Size(); got != int(size) {
+			t.Fatalf("expected pool size %d, got %d", size, got)
+		}
+		if got := len(pool.Stats().Workers); got != int(size) {
+			t.Fatalf("expected %d workers in stats, got %d", size, got)
+		}
+
+		output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+		if err != nil {
+			t.Fatalf("Translate returned error %v", err)
+		}
+		if output != helloWorldTranslation {
+			t.Errorf("unexpected output %s", output)
+		}
+	}
+
+	if err := pool.Resize(ctx, 0); err == nil {
+		t.Error("expected error resizing pool to zero")
+	}
+}
+
+func TestPool_StickyRouting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+			CacheSize:   100,
+		},
+		PoolSize:      3,
+		StickyRouting: true,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	const requestsCount = 10
+	for range requestsCount {
+		output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+		if err != nil {
+			t.Fatalf("Translate returned error %v", err)
+		}
+		if output != helloWorldTranslation {
+			t.Errorf("unexpected output %s", output)
+		}
+	}
+
+	// without load the same text must mostly go to the same worker
+	var maxRequests uint64
+	for _, w := range pool.Stats().Workers {
+		maxRequests = max(maxRequests, w.Requests)
+	}
+	if maxRequests < requestsCount/2 {
+		t.Errorf("expected requests to stick to one worker, got %+v", pool.Stats().Workers)
+	}
+}
+
+func TestPool_Recycle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 1,
+		Recycle:  gobergamot.RecyclePolicy{MaxRequests: 2},
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	// translator is replaced in background, so requests are sent until it happens
+	for pool.Stats().Recycled == 0 {
+		output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+		if err != nil {
+			t.Fatalf("Translate returned error %v", err)
+		}
+		if output != helloWorldTranslation {
+			t.Errorf("unexpected output %s", output)
+		}
+	}
+
+	output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Goodbye World"})
+	if err != nil {
+		t.Fatalf("Translate with recycled translator returned error %v", err)
+	}
+	if output != goodbyeWorldTranslation {
+		t.Errorf("unexpected output %s", output)
+	}
+}
+
+// trappingGemm fails the first matrix multiplication after gate is closed, which traps the translator.
+type trappingGemm struct {
+	gobergamot.GemmBackend
+	gate   <-chan struct{}
+	err    error
+	called *atomic.Bool
+}
+
+func (g trappingGemm) Int8MultiplyAndAddBias(
+	ctx context.Context, mod api.Module,
+	inputAPrepared uint32, scaleA, zeroPointA float32,
+	inputBPrepared uint32, scaleB, zeroPointB float32,
+	inputBiasPrepared uint32, unquantMultiplier float32,
+	rowsA, width, colsB uint32, output uint32,
+) error {
+	if !g.called.Swap(true) {
+		<-g.gate
+		return g.err
+	}
+	return g.GemmBackend.Int8MultiplyAndAddBias(ctx, mod,
+		inputAPrepared, scaleA, zeroPointA,
+		inputBPrepared, scaleB, zeroPointB,
+		inputBiasPrepared, unquantMultiplier,
+		rowsA, width, colsB, output,
+	)
+}
+
+func TestPool_RecyclePoisoned(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	gate := make(chan struct{})
+	gemmErr := errors.New("gemm failed")
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			CompileConfig: wasm.CompileConfig{GemmBackend: trappingGemm{
+				GemmBackend: gobergamot.NativeGemm(),
+				gate:        gate,
+				err:         gemmErr,
+				called:      new(atomic.Bool),
+			}},
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	translate := func(errChan chan<- error) {
+		go func() {
+			output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+			if err == nil && output != helloWorldTranslation {
+				err = fmt.Errorf("unexpected output %s", output)
+			}
+			errChan <- err
+		}()
+	}
+	// the only worker traps on the first request while others are queued
+	trapped := make(chan error, 1)
+	translate(trapped)
+	waitStats(t, pool, func(stats gobergamot.PoolStats) bool { return stats.BusyWorkers == 1 })
+	queued := make(chan error, 3)
+	for i := range 3 {
+		translate(queued)
+		waitStats(t, pool, func(stats gobergamot.PoolStats) bool { return stats.QueueLength == i+1 })
+	}
+	close(gate)
+
+	if err := <-trapped; !errors.Is(err, gemmErr) {
+		t.Errorf("expected trapped request to fail with gemm error, got %v", err)
+	}
+	// queued requests wait for the replacement translator instead of failing with the poisoned one
+	for range 3 {
+		if err := <-queued; err != nil {
+			t.Errorf("Translate of queued request returned error %v", err)
+		}
+	}
+	if stats := pool.Stats(); stats.Recycled != 1 || stats.Errors != 1 {
+		t.Errorf("expected poisoned translator to be recycled once with a single error, got %+v", stats)
+	}
+}
+
+func TestPool_RecycleIdleMaxAge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 1,
+		Recycle:  gobergamot.RecyclePolicy{MaxAge: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	// the worker gets no requests, so it is recycled by the age timer only
+	waitStats(t, pool, func(stats gobergamot.PoolStats) bool { return stats.Recycled >= 2 })
+
+	output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+	if err != nil {
+		t.Fatalf("Translate with recycled translator returned error %v", err)
+	}
+	if output != helloWorldTranslation {
+		t.Errorf("unexpected output %s", output)
+	}
+}
+
+func TestPool_RecycleSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle:    testBundle(t),
+			SnapshotMemory: true,
+		},
+		PoolSize: 1,
+		Recycle:  gobergamot.RecyclePolicy{MaxRequests: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Fatalf("failed to close pool: %v", err)
+		}
+	})
+
+	// translator is restored from the snapshot in place after every request
+	for i := range 3 {
+		output, err := pool.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"})
+		if err != nil {
+			t.Fatalf("Translate returned error %v", err)
+		}
+		if output != helloWorldTranslation {
+			t.Errorf("unexpected output %s", output)
+		}
+		// the worker restores the snapshot after sending the response
+		waitStats(t, pool, func(stats gobergamot.PoolStats) bool { return stats.Recycled == uint64(i+1) })
+	}
+}
+
+func TestPool_Progress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+
+	var progress []uint
+	pool, err := gobergamot.NewPool(ctx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle: testBundle(t),
+		},
+		PoolSize: 3,
+		Progress: func(ready, total uint) {
+			if total != 3 {
+				t.Errorf("unexpected total %d", total)
+			}
+			progress = append(progress, ready)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPool returned error %v", err)
+	}
+	if err := pool.Close(ctx); err != nil {
+		t.Fatalf("failed to close pool: %v", err)
+	}
+
+	if len(progress) != 3 || progress[0] != 1 || progress[1] != 2 || progress[2] != 3 {
+		t.Errorf("unexpected progress calls %v", progress)
+	}
+}
+
+func TestPool_BuildFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+	buildCtx, cancelBuild := context.WithCancel(ctx)
+	defer cancelBuild()
+
+	handler := newRecordingHandler()
+	// the first built translator aborts loading of the others, which are built concurrently
+	pool, err := gobergamot.NewPool(buildCtx, gobergamot.PoolConfig{
+		Config: gobergamot.Config{
+			FilesBundle:    testBundle(t),
+			WASMUseContext: true,
+			Logger:         slog.New(handler),
+		},
+		PoolSize: 3,
+		Progress: func(ready, total uint) {
+			if ready == 1 {
+				cancelBuild()
+			}
+		},
+	})
+	if err == nil {
+		_ = pool.Close(ctx)
+		t.Fatal("expected NewPool to fail with aborted translators")
+	}
+
+	var created, closed, engineClosed int
+	for _, rec := range handler.get() {
+		switch rec.msg {
+		case "translator created":
+			created++
+		case "translator closed":
+			closed++
+		case "engine closed":
+			engineClosed++
+		}
+	}
+	if created == 0 || created == 3 {
+		t.Errorf("expected some translators to be built before the failure, got %d", created)
+	}
+	if closed != created {
+		t.Errorf("expected all %d built translators to be closed, got %d", created, closed)
+	}
+	if engineClosed != 1 {
+		t.Errorf("expected engine to be closed, got %d records", engineClosed)
+	}
+}
@@ -0,0 +1,342 @@
+package gobergamot_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxnuo/gobergamot"
+)
+
+func TestProfiler(t *testing.T) {
+	ctx := context.Background()
+	profiler := gobergamot.NewProfiler(time.Millisecond)
+
+	translator, err := gobergamot.New(ctx, gobergamot.Config{FilesBundle: testBundle(t), CacheDir: t.TempDir(), Profiler: profiler})
+	if err != nil {
+		t.Fatalf("failed to create translator: %v", err)
+	}
+	defer translator.Close(ctx)
+
+	var profile bytes.Buffer
+	if err := profiler.Start(&profile); err != nil {
+		t.Fatalf("failed to start profiler: %v", err)
+	}
+	if err := profiler.Start(io.Discard); !errors.Is(err, gobergamot.ErrProfilerRunning) {
+		t.Errorf("expected ErrProfilerRunning, got %v", err)
+	}
+	const text, wantedOutput = "Hello, World!", "Здравствуйте, Мир!"
+	output, err := translator.Translate(ctx, gobergamot.TranslationRequest{Text: text})
+	if err != nil {
+		t.Fatalf("failed to translate: %v", err)
+	}
+	if output != wantedOutput {
+		t.Errorf("expected: %s\ngot: %s", wantedOutput, output)
+	}
+	if err := profiler.Stop(); err != nil {
+		t.Fatalf("failed to stop profiler: %v", err)
+	}
+
+	r, err := gzip.NewReader(&profile)
+	if err != nil {
+		t.Fatalf("profile is not gzip-compressed: %v", err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to decompress profile: %v", err)
+	}
+	decoded, err := decodeProfile(content)
+	if err != nil {
+		t.Fatalf("failed to decode profile: %v", err)
+	}
+	checkProfile(t, decoded, time.Millisecond)
+
+	// stopped profiler does nothing
+	if err := profiler.Stop(); err != nil {
+		t.Errorf("failed to stop stopped profiler: %v", err)
+	}
+}
+
+func TestProfiler_CacheDir(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	// the module is incompatible, but a persisted cache entry would be created before the check
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	_, err := gobergamot.NewEngine(ctx, gobergamot.EngineConfig{
+		WASM:     module,
+		CacheDir: cacheDir,
+		Profiler: gobergamot.NewProfiler(time.Millisecond),
+	})
+	if !errors.Is(err, gobergamot.ErrIncompatibleWASM) {
+		t.Fatalf("expected ErrIncompatibleWASM, got %v", err)
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatalf("failed to read cache directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected profiled engine to keep compiled code in memory, got cache entries %v", entries)
+	}
+}
+
+// checkProfile checks that the profile is a consistent CPU profile sampled with the interval
+// and that matrix multiplication has samples.
+func checkProfile(t *testing.T, profile *decodedProfile, interval time.Duration) {
+	t.Helper()
+	wantTypes := []profileValueType{{"samples", "count"}, {"cpu", "nanoseconds"}}
+	if !slices.Equal(profile.sampleTypes, wantTypes) {
+		t.Errorf("expected sample types %v, got %v", wantTypes, profile.sampleTypes)
+	}
+	if want := (profileValueType{"cpu", "nanoseconds"}); profile.periodType != want {
+		t.Errorf("expected period type %v, got %v", want, profile.periodType)
+	}
+	if profile.period != interval.Nanoseconds() {
+		t.Errorf("expected period %d, got %d", interval.Nanoseconds(), profile.period)
+	}
+	for id, functionID := range profile.locations {
+		if _, ok := profile.functions[functionID]; !ok {
+			t.Errorf("location %d refers to missing function %d", id, functionID)
+		}
+	}
+	for id, name := range profile.functions {
+		if name == "" {
+			t.Errorf("function %d has no name", id)
+		}
+	}
+
+	if len(profile.samples) == 0 {
+		t.Fatal("expected samples in profile")
+	}
+	var gemmSamples int64
+	for _, sample := range profile.samples {
+		if len(sample.values) != len(profile.sampleTypes) {
+			t.Errorf("expected %d values of sample, got %v", len(profile.sampleTypes), sample.values)
+			continue
+		}
+		if sample.values[0] <= 0 || sample.values[1] != sample.values[0]*profile.period {
+			t.Errorf("expected positive count and count*period time, got %v", sample.values)
+		}
+		if len(sample.locations) == 0 {
+			t.Error("expected sample to have locations")
+		}
+		gemm := false
+		for _, id := range sample.locations {
+			functionID, ok := profile.locations[id]
+			if !ok {
+				t.Errorf("sample refers to missing location %d", id)
+				continue
+			}
+			gemm = gemm || strings.HasPrefix(profile.functions[functionID], "wasm_gemm.")
+		}
+		if gemm {
+			gemmSamples += sample.values[0]
+		}
+	}
+	// most of translation time goes to matrix multiplication, which takes far longer than the interval
+	if gemmSamples == 0 {
+		t.Error("expected samples of wasm_gemm functions in profile")
+	}
+}
+
+// decodedProfile holds fields of a pprof profile checked by tests, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+type decodedProfile struct {
+	sampleTypes []profileValueType
+	samples     []profileSample
+	// locations are IDs of functions of the first line by location IDs
+	locations map[uint64]uint64
+	// functions are names of functions by their IDs
+	functions  map[uint64]string
+	periodType profileValueType
+	period     int64
+}
+
+type profileValueType struct {
+	typ, unit string
+}
+
+type profileSample struct {
+	locations []uint64
+	values    []int64
+}
+
+// decodeProfile decodes an uncompressed pprof profile with a minimal protobuf reader.
+func decodeProfile(content []byte) (*decodedProfile, error) {
+	profile := &decodedProfile{locations: make(map[uint64]uint64), functions: make(map[uint64]string)}
+	// strings are referenced by indexes in the string table, which may follow the references
+	var (
+		table         []string
+		sampleTypes   [][2]uint64
+		periodType    [2]uint64
+		functionNames = make(map[uint64]uint64)
+	)
+	valueType := func(data []byte) (v [2]uint64, err error) {
+		err = readProto(data, func(field int, value uint64, _ []byte) error {
+			if field == 1 || field == 2 {
+				v[field-1] = value
+			}
+			return nil
+		})
+		return v, err
+	}
+	err := readProto(content, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 1: // sample_type
+			v, err := valueType(data)
+			sampleTypes = append(sampleTypes, v)
+			return err
+		case 2: // sample
+			var sample profileSample
+			err := readProto(data, func(field int, value uint64, data []byte) error {
+				values, err := protoUints(value, data)
+				switch field {
+				case 1: // location_id
+					sample.locations = append(sample.locations, values...)
+				case 2: // value
+					for _, v := range values {
+						sample.values = append(sample.values, int64(v))
+					}
+				}
+				return err
+			})
+			profile.samples = append(profile.samples, sample)
+			return err
+		case 4: // location
+			var id, functionID uint64
+			err := readProto(data, func(field int, value uint64, data []byte) error {
+				switch field {
+				case 1: // id
+					id = value
+				case 4: // line
+					if functionID != 0 {
+						return nil
+					}
+					return readProto(data, func(field int, value uint64, _ []byte) error {
+						if field == 1 { // function_id
+							functionID = value
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			profile.locations[id] = functionID
+			return err
+		case 5: // function
+			var id, name uint64
+			err := readProto(data, func(field int, value uint64, _ []byte) error {
+				switch field {
+				case 1: // id
+					id = value
+				case 2: // name
+					name = value
+				}
+				return nil
+			})
+			functionNames[id] = name
+			return err
+		case 6: // string_table
+			table = append(table, string(data))
+		case 11: // period_type
+			var err error
+			periodType, err = valueType(data)
+			return err
+		case 12: // period
+			profile.period = int64(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i uint64) (string, error) {
+		if i >= uint64(len(table)) {
+			return "", fmt.Errorf("string index %d is out of table of %d strings", i, len(table))
+		}
+		return table[i], nil
+	}
+	resolve := func(v [2]uint64) (profileValueType, error) {
+		typ, err := str(v[0])
+		if err != nil {
+			return profileValueType{}, err
+		}
+		unit, err := str(v[1])
+		return profileValueType{typ, unit}, err
+	}
+	for _, v := range sampleTypes {
+		sampleType, err := resolve(v)
+		if err != nil {
+			return nil, err
+		}
+		profile.sampleTypes = append(profile.sampleTypes, sampleType)
+	}
+	if profile.periodType, err = resolve(periodType); err != nil {
+		return nil, err
+	}
+	for id, name := range functionNames {
+		if profile.functions[id], err = str(name); err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
+
+// readProto calls visit for every field of a protobuf message with the value of a varint field
+// or the content of a length-delimited one. Other wire types are not used by pprof.
+func readProto(content []byte, visit func(field int, value uint64, data []byte) error) error {
+	for len(content) != 0 {
+		tag, n := binary.Uvarint(content)
+		if n <= 0 {
+			return errors.New("malformed field tag")
+		}
+		content = content[n:]
+		value, n := binary.Uvarint(content)
+		if n <= 0 {
+			return errors.New("malformed field value")
+		}
+		content = content[n:]
+
+		var data []byte
+		switch wireType := tag & 7; wireType {
+		case 0:
+		case 2:
+			if uint64(len(content)) < value {
+				return errors.New("truncated field")
+			}
+			data, content = content[:value], content[value:]
+		default:
+			return fmt.Errorf("unexpected wire type %d", wireType)
+		}
+		if err := visit(int(tag>>3), value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoUints returns values of a repeated integer field, which is either a single varint or packed.
+func protoUints(value uint64, data []byte) ([]uint64, error) {
+	if data == nil {
+		return []uint64{value}, nil
+	}
+	var values []uint64
+	for len(data) != 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("malformed packed field")
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values, nil
+}
@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
 
 	"github.com/xxnuo/gobergamot"
 	"github.com/xxnuo/gobergamot/internal/wasm"
@@ -283,6 +287,39 @@ func TestTranslator_TranslateEnZh(t *testing.T) {
 	}
 }
 
+func TestTranslator_TranslateCanceled(t *testing.T) {
+	ctx := context.Background()
+
+	translator, err := gobergamot.New(ctx, gobergamot.Config{
+		FilesBundle:    testBundle(t),
+		WASMUseContext: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create translator: %v", err)
+	}
+	defer func() {
+		if err := translator.Close(ctx); err != nil {
+			t.Fatalf("failed to close translator: %v", err)
+		}
+	}()
+
+	canceledCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = translator.Translate(canceledCtx, gobergamot.TranslationRequest{Text: longText})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+
+	// translator must stay usable after aborted translation
+	output, err := translator.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello, World!"})
+	if err != nil {
+		t.Fatalf("failed to translate after canceled translation: %v", err)
+	}
+	if output != "Здравствуйте, Мир!" {
+		t.Errorf("unexpected output %s", output)
+	}
+}
+
 // 从文件路径加载模型文件
 func loadModelFile(path string) (io.Reader, error) {
 	// 获取项目根目录
@@ -460,3 +497,228 @@ type readerWrapper struct {
 func (r readerWrapper) Read(p []byte) (n int, err error) {
 	return r.r.Read(p)
 }
+
+func TestTranslator_NativeGEMM(t *testing.T) {
+	ctx := context.Background()
+
+	translator, err := gobergamot.New(ctx, gobergamot.Config{
+		CompileConfig: wasm.CompileConfig{GemmBackend: gobergamot.NativeGemm()},
+		FilesBundle:   testBundle(t),
+	})
+	if err != nil {
+		t.Fatalf("failed to create translator: %v", err)
+	}
+	defer func() {
+		if err := translator.Close(ctx); err != nil {
+			t.Fatalf("failed to close translator: %v", err)
+		}
+	}()
+
+	tests := []struct {
+		text, wantedOutput string
+	}{
+		{text: "Hello, World!", wantedOutput: "Здравствуйте, Мир!"},
+		{text: "Invalid format: invalid regex", wantedOutput: "Неверный формат: недействительный regex"},
+	}
+	for _, tt := range tests {
+		output, err := translator.Translate(ctx, gobergamot.TranslationRequest{Text: tt.text})
+		if err != nil {
+			t.Fatalf("failed to translate %q: %v", tt.text, err)
+		}
+		if output != tt.wantedOutput {
+			t.Errorf("\nexpected: %s\ngot: %s", tt.wantedOutput, output)
+		}
+	}
+}
+
+func TestTranslator_CacheDir(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	stale := filepath.Join(cacheDir, "bergamot-0000000000000000-wazero-v0.0.0")
+	if err := os.Mkdir(stale, 0o755); err != nil {
+		t.Fatalf("failed to create stale cache entry: %v", err)
+	}
+
+	// the second translator must be created from the cache filled by the first one
+	for range 2 {
+		translator, err := gobergamot.New(ctx, gobergamot.Config{
+			FilesBundle: testBundle(t),
+			CacheDir:    cacheDir,
+		})
+		if err != nil {
+			t.Fatalf("failed to create translator: %v", err)
+		}
+		if err := translator.Close(ctx); err != nil {
+			t.Fatalf("failed to close translator: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected stale cache entry to be removed, got %v", err)
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatalf("failed to read cache directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected single cache entry, got %d", len(entries))
+	}
+	var files int
+	err = filepath.WalkDir(filepath.Join(cacheDir, entries[0].Name()), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files++
+		}
+		return err
+	})
+	if err != nil || files == 0 {
+		t.Errorf("expected compiled module in cache entry, got %d files, error %v", files, err)
+	}
+}
+
+// failingGemm fails matrix multiplication, which is called by translation only.
+type failingGemm struct {
+	gobergamot.GemmBackend
+	err error
+}
+
+func (g failingGemm) Int8MultiplyAndAddBias(
+	ctx context.Context, mod api.Module,
+	inputAPrepared uint32, scaleA, zeroPointA float32,
+	inputBPrepared uint32, scaleB, zeroPointB float32,
+	inputBiasPrepared uint32, unquantMultiplier float32,
+	rowsA, width, colsB uint32, output uint32,
+) error {
+	return g.err
+}
+
+func TestTranslator_Trap(t *testing.T) {
+	ctx := context.Background()
+	gemmErr := errors.New("gemm failed")
+
+	translator, err := gobergamot.New(ctx, gobergamot.Config{
+		CompileConfig: wasm.CompileConfig{GemmBackend: failingGemm{GemmBackend: gobergamot.NativeGemm(), err: gemmErr}},
+		FilesBundle:   testBundle(t),
+	})
+	if err != nil {
+		t.Fatalf("failed to create translator: %v", err)
+	}
+	defer func() {
+		if err := translator.Close(ctx); err != nil {
+			t.Fatalf("failed to close translator: %v", err)
+		}
+	}()
+
+	_, err = translator.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello, World!"})
+	var trap *gobergamot.WASMTrapError
+	if !errors.As(err, &trap) {
+		t.Fatalf("expected WASMTrapError, got %v", err)
+	}
+	if !errors.Is(err, gemmErr) {
+		t.Errorf("expected trap to wrap gemm error, got %v", err)
+	}
+	if trap.Op != "translate" || trap.HostFunc != "wasm_gemm.int8_multiply_and_add_bias" || trap.Reason != gemmErr.Error() {
+		t.Errorf("unexpected trap operation %q, host function %q or reason %q", trap.Op, trap.HostFunc, trap.Reason)
+	}
+	if len(trap.Stack) < 2 {
+		t.Fatalf("expected stack trace through Bergamot functions, got %v", trap.Stack)
+	}
+	// only the debug build has DWARF sections to resolve C++ source locations
+	if sources := trap.Stack[1].Sources; wasm.DebugInfo && len(sources) == 0 {
+		t.Errorf("expected debug build to resolve source locations of %s", trap.Stack[1].Function)
+	} else if !wasm.DebugInfo && len(sources) != 0 {
+		t.Errorf("expected release build to have no source locations of %s, got %v", trap.Stack[1].Function, sources)
+	}
+
+	if !errors.Is(translator.Poisoned(), gemmErr) {
+		t.Errorf("expected translator to be poisoned by the trap, got %v", translator.Poisoned())
+	}
+	if state := translator.State(); state != gobergamot.TranslatorPoisoned {
+		t.Errorf("expected poisoned state, got %v", state)
+	}
+	_, err = translator.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello, World!"})
+	if !errors.Is(err, gobergamot.ErrTranslatorPoisoned) {
+		t.Errorf("expected poisoned translator error, got %v", err)
+	}
+}
+
+func TestTranslator_MaxMemory(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name           string
+		maxMemoryBytes uint64
+		wantErr        error
+	}{
+		{name: "invalid", maxMemoryBytes: 1, wantErr: gobergamot.ErrInvalidMaxMemory},
+		// workspace Marian allocates for the model does not fit
+		{name: "too small", maxMemoryBytes: 48 << 20, wantErr: gobergamot.ErrOutOfMemory},
+		{name: "enough", maxMemoryBytes: 1 << 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translator, err := gobergamot.New(ctx, gobergamot.Config{
+				FilesBundle:    testBundle(t),
+				MaxMemoryBytes: tt.maxMemoryBytes,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create translator: %v", err)
+			}
+			defer translator.Close(ctx)
+
+			if _, err := translator.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello, World!"}); err != nil {
+				t.Errorf("failed to translate: %v", err)
+			}
+		})
+	}
+}
+
+func TestTranslator_ConcurrentUseAndClose(t *testing.T) {
+	ctx := context.Background()
+	translator, err := gobergamot.New(ctx, gobergamot.Config{FilesBundle: testBundle(t)})
+	if err != nil {
+		t.Fatalf("failed to create translator: %v", err)
+	}
+	if state := translator.State(); state != gobergamot.TranslatorReady {
+		t.Errorf("expected ready state, got %v", state)
+	}
+
+	const goroutines = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			translated, err := translator.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello, World!"})
+			if err == nil && translated != "Здравствуйте, Мир!" {
+				err = fmt.Errorf("unexpected translation %q", translated)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("failed to translate concurrently: %v", err)
+		}
+	}
+
+	if err := translator.Close(ctx); err != nil {
+		t.Fatalf("failed to close translator: %v", err)
+	}
+	if err := translator.Close(ctx); err != nil {
+		t.Errorf("expected second Close to do nothing, got %v", err)
+	}
+	if state := translator.State(); state != gobergamot.TranslatorClosed {
+		t.Errorf("expected closed state, got %v", state)
+	}
+	if _, err := translator.Translate(ctx, gobergamot.TranslationRequest{Text: "Hello World"}); !errors.Is(err, gobergamot.ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
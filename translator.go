@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	embind "github.com/jerbob92/wazero-emscripten-embind"
@@ -53,17 +56,71 @@ type Config struct {
 	// https://marian-nmt.github.io/docs/cmd/marian-decoder/
 	BergamotOptions map[string]any
 
+	// WASM is a Bergamot module binary used instead of EmbeddedWASM, e.g. a newer bergamot-translator build
+	// or one built with other CMake options. It must be built with the same embind bindings and
+	// wasm_gemm imports. The module is checked before use, mismatches are reported with WASMABIError.
+	WASM []byte
+
+	// WASMCache keeps compiled Bergamot module to speed up creation of following Translator instances.
+	// If nil, a cache persisted in CacheDir is created and closed along with Translator.
+	// Set it to wazero.NewCompilationCache() to keep compiled code in memory only.
 	WASMCache wazero.CompilationCache
 
+	// CacheDir is a directory where compiled Bergamot module is kept between process runs
+	// if WASMCache is nil. If empty, DefaultCacheDir is used. Entries of the directory are keyed
+	// by the WASM module hash and wazero version and entries left by other versions are removed.
+	// Entries of modules set with WASM are also removed if they have not been used for 30 days.
+	// If the directory can not be used, compiled code is kept in memory only.
+	CacheDir string
+
 	// WASMUseContext defines if WASM functions execution must be canceled upon context.Context cancellation.
 	// Equivalent to wazero.RuntimeConfig WithCloseOnContextDone method parameter.
+	//
+	// Canceling a translation closes the WASM module, so Translator keeps model data in memory
+	// and transparently re-instantiates the module afterward. The canceled call returns an error
+	// wrapping context error, while next calls are processed as usual.
 	WASMUseContext bool
+
+	// SnapshotMemory enables keeping a copy of the module linear memory and globals taken after Translator
+	// initialization. A translation failed inside the module may leave its state inconsistent,
+	// so Translator restores the copy, which is much faster than creating a new Translator.
+	// Pool also restores it to recycle translators by RecyclePolicy MaxRequests and MaxAge.
+	// The copy takes as much memory as the module has after loading the model.
+	// Restoring it empties Bergamot translation cache (see CacheSize), which lives in the module memory,
+	// so translations cached by the Translator are lost, e.g. for PoolConfig.StickyRouting.
+	// The copy is restored into the same module instance only, since embind wrappers can not be bound
+	// to objects copied into another instance. New translators, including Pool workers added by Resize
+	// or replacing recycled ones, are not cloned from it and still load the model.
+	SnapshotMemory bool
+
+	// MaxMemoryBytes limits the module linear memory, rounded down to 64 KiB WASM pages.
+	// If zero, the limit encoded in the module is used. Loading a model or translating a text
+	// which requires more memory fails with ErrOutOfMemory, and the Translator is poisoned
+	// by the latter unless SnapshotMemory is enabled. The limit does not include model files
+	// kept for WASMUseContext and memory snapshot.
+	MaxMemoryBytes uint64
+
+	// ExecutionMode selects wazero compiler or interpreter. By default the compiler is used
+	// if the platform supports it. Forcing the compiler where it is not supported
+	// fails with ErrCompilerUnsupported, see CompilerSupported.
+	ExecutionMode ExecutionMode
+
+	// Logger receives records of Translator lifecycle events, like model loading and WASM traps.
+	// If Stderr and Stdout are nil, Bergamot logs are written to it with NewMarianLogWriter.
+	// If nil, nothing is logged and Bergamot logs go to Stderr and Stdout.
+	Logger *slog.Logger
+
+	// Profiler samples WASM call stacks to find out where translation time goes, see Profiler.
+	// It slows translation down, so it must be set only to profile. Code compiled for profiling
+	// is not persisted in CacheDir.
+	Profiler *Profiler
 }
 
 var (
 	ErrModelMissing            = errors.New("model is required")
 	ErrVocabulariesMissing     = errors.New("at least one vocabulary is required")
 	ErrLexicalShortlistMissing = errors.New("lexical shortlist is required")
+	ErrInvalidMaxMemory        = errors.New("max memory must be between 64 KiB and 4 GiB")
 )
 
 func (cfg Config) Validate() error {
@@ -77,7 +134,7 @@ func (cfg Config) Validate() error {
 	if cfg.LexicalShortlist == nil {
 		err = errors.Join(err, ErrLexicalShortlistMissing)
 	}
-	return err
+	return errors.Join(err, cfg.engineConfig().Validate())
 }
 
 // DefaultBergamotOptions provides default options for WASM Bergamot translator worker
@@ -99,81 +156,126 @@ func DefaultBergamotOptions() map[string]any {
 }
 
 // Translator represents a Bergamot translator worker in Go.
+// It is safe for concurrent use, calls are serialised since the module instance runs a single thread.
+// See TranslatorState for its lifecycle.
 type Translator struct {
+	// mu serialises calls using the module instance
+	mu sync.Mutex
+	// state is TranslatorState, it is changed with mu held and read without it
+	state atomic.Int32
+
 	embindEngine embind.Engine
-	wasmRuntime  wazero.Runtime
+	engine       *Engine
 	cfg          Config
+	logger       *slog.Logger
+
+	// ownsEngine is set if the Engine was created by New, so it is closed along with Translator
+	ownsEngine bool
 
 	model *gen.ClassTranslationModel
 	svc   *gen.ClassBlockingService
 
 	module api.Module
+	// memory backs the module memory and records grows refused by the memory limit
+	memory *memoryAllocator
+
+	// objects are embind objects created in the module instance to delete them
+	objects embindObjects
+	// leaked are classes of the first objects left alive by translations, detected only in debug builds
+	leaked []string
+
+	// files keeps model data to re-instantiate the module after an aborted translation.
+	// It is set only if Config.WASMUseContext is enabled.
+	files *bundleBytes
+
+	// snapshot is set if Config.SnapshotMemory is enabled
+	snapshot *memorySnapshot
+
+	// poison is set along with TranslatorPoisoned state to reject following calls:
+	// it is WASMTrapError after a trap, or the error of module restore after an aborted translation
+	poison error
 }
 
 // New compiles Bergamot module and creates TranslationModel and BlockingService instances
-// to be used in Translator
+// to be used in Translator. The Translator has its own Engine, use Engine.NewTranslator
+// to share one between many translators.
 func New(ctx context.Context, cfg Config) (*Translator, error) {
 	err := cfg.Validate()
 	if err != nil {
 		return nil, err
 	}
-	if cfg.BergamotOptions == nil {
-		cfg.BergamotOptions = DefaultBergamotOptions()
-	}
 
-	tr := &Translator{
-		embindEngine: embind.CreateEngine(embind.NewConfig()),
-		cfg:          cfg,
+	engine, err := NewEngine(ctx, cfg.engineConfig())
+	if err != nil {
+		return nil, err
 	}
-
-	wasmRuntimeConfig := wazero.NewRuntimeConfig().
-		// sentencepiece uses multithreading - so we need WASM threads feature to use Bergamot
-		WithCoreFeatures(api.CoreFeaturesV2 | experimental.CoreFeaturesThreads).
-		WithCloseOnContextDone(cfg.WASMUseContext)
-	if cfg.WASMCache != nil {
-		wasmRuntimeConfig = wasmRuntimeConfig.WithCompilationCache(cfg.WASMCache)
+	tr, err := engine.NewTranslator(ctx, cfg)
+	if err != nil {
+		_ = engine.Close(context.WithoutCancel(ctx))
+		return nil, err
 	}
-	tr.wasmRuntime = wazero.NewRuntimeWithConfig(ctx, wasmRuntimeConfig)
+	tr.ownsEngine = true
+	return tr, nil
+}
 
-	ctx = tr.embindEngine.Attach(ctx)
+// instantiate creates Bergamot module instance and loads given files into TranslationModel.
+func (t *Translator) instantiate(ctx context.Context, files FilesBundle) error {
+	cfg := t.cfg
+	t.setState(TranslatorInitializing)
+	t.embindEngine = embind.CreateEngine(embind.NewConfig())
+	ctx = t.embindEngine.Attach(ctx)
+	t.memory = newMemoryAllocator(t.engine.memoryLimit)
+	ctx = experimental.WithMemoryAllocator(ctx, t.memory)
 
-	tr.module, err = wasm.CompileBergamot(ctx, tr.wasmRuntime, tr.embindEngine, cfg.CompileConfig)
+	mod, err := t.engine.instantiate(ctx, t.embindEngine, cfg.CompileConfig)
 	if err != nil {
-		return nil, fmt.Errorf("CompileBergamot: %w", err)
+		if mod != nil {
+			_ = mod.Close(context.WithoutCancel(ctx))
+		}
+		return fmt.Errorf("InstantiateBergamot: %w", err)
 	}
+	t.module = mod
+	t.objects = embindObjects{}
 	bundle, err := enrichAlignedMemoriesBundle(
 		ctx,
-		tr.embindEngine,
-		tr.module,
-		newAlignedMemoryDataBundle(cfg.Model, cfg.LexicalShortlist, cfg.Vocabularies),
+		t.embindEngine,
+		t.module,
+		newAlignedMemoryDataBundle(files.Model, files.LexicalShortlist, files.Vocabularies),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get aligned memory views: %w", err)
+		return fmt.Errorf("failed to get aligned memory views: %w", err)
+	}
+	for _, memory := range append([]alignedMemoryInfo{bundle.model, bundle.shortlist}, bundle.vocabularies...) {
+		if memory.memory != nil {
+			t.objects.keep("AlignedMemory", memory.memory)
+		}
 	}
 
-	tr.svc, err = gen.NewClassBlockingService(tr.embindEngine, ctx, map[string]any{"cacheSize": uint32(cfg.CacheSize)})
+	t.svc, err = gen.NewClassBlockingService(t.embindEngine, ctx, map[string]any{"cacheSize": uint32(cfg.CacheSize)})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get blocking service: %w", err)
+		return fmt.Errorf("failed to get blocking service: %w", err)
 	}
+	t.objects.keep("BlockingService", t.svc)
 
-	vocabularies, err := gen.NewClassAlignedMemoryList(tr.embindEngine, ctx)
+	vocabularies, err := gen.NewClassAlignedMemoryList(t.embindEngine, ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create aligned memory list: %w", err)
+		return fmt.Errorf("failed to create aligned memory list: %w", err)
 	}
+	t.objects.keep("AlignedMemoryList", vocabularies)
 
 	// Add all vocabularies to the list
 	for _, vocab := range bundle.vocabularies {
 		if err := vocabularies.Push_back(ctx, vocab.asEmbindClass()); err != nil {
-			return nil, fmt.Errorf("failed to push back vocabulary: %w", err)
+			return fmt.Errorf("failed to push back vocabulary: %w", err)
 		}
 	}
 
 	bergamotCfg, err := yaml.Marshal(cfg.BergamotOptions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert bergamot (marian) options to YAML: %w", err)
+		return fmt.Errorf("failed to convert bergamot (marian) options to YAML: %w", err)
 	}
-	tr.model, err = gen.NewClassTranslationModel(
-		tr.embindEngine,
+	t.model, err = gen.NewClassTranslationModel(
+		t.embindEngine,
 		ctx,
 		string(bergamotCfg),
 		bundle.model.asEmbindClass(),
@@ -183,10 +285,17 @@ func New(ctx context.Context, cfg Config) (*Translator, error) {
 		nil,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create translation model: %w", err)
+		return fmt.Errorf("failed to create translation model: %w", err)
 	}
+	t.objects.keep("TranslationModel", t.model)
 
-	return tr, nil
+	if cfg.SnapshotMemory {
+		if err := t.takeSnapshot(); err != nil {
+			return fmt.Errorf("failed to take memory snapshot: %w", err)
+		}
+	}
+	t.setState(TranslatorReady)
+	return nil
 }
 
 // TranslationOptions are equivalent to ResponseOptions in Bergamot.
@@ -221,35 +330,148 @@ func (t *Translator) Translate(ctx context.Context, request TranslationRequest)
 
 // TranslateMultiple translates a batch of text provided in the requests into a model target language.
 func (t *Translator) TranslateMultiple(ctx context.Context, requests ...TranslationRequest) ([]string, error) {
-	input, err := gen.NewClassVectorString(t.embindEngine, ctx)
+	result, err := t.translate(ctx, requests)
 	if err != nil {
 		return nil, err
 	}
+	return result.outputs, nil
+}
+
+type translationResult struct {
+	outputs []string
+	// sentences is a number of sentences Bergamot split the source texts into
+	sentences int
+}
+
+func (t *Translator) translate(ctx context.Context, requests []TranslationRequest) (translationResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkUsable(); err != nil {
+		return translationResult{}, err
+	}
+	t.beginCall()
+	t.memory.resetRefused()
+	result, err := t.translateInModule(ctx, requests)
+	if err != nil && t.files != nil && ctx.Err() != nil && t.module.IsClosed() {
+		// wazero has closed the module to abort the translation,
+		// so a new instance is created to keep the Translator usable
+		if restoreErr := t.restore(context.WithoutCancel(ctx)); restoreErr != nil {
+			t.logger.Error("failed to restore module after aborted translation", slog.Any("error", restoreErr))
+			t.poison = fmt.Errorf("failed to restore module: %w", restoreErr)
+			t.setState(TranslatorPoisoned)
+			return translationResult{}, errors.Join(
+				fmt.Errorf("translation aborted: %w", ctx.Err()),
+				fmt.Errorf("failed to restore module: %w", restoreErr),
+			)
+		}
+		t.logger.Debug("module restored after aborted translation")
+		return translationResult{}, fmt.Errorf("translation aborted: %w", ctx.Err())
+	}
+	err = outOfMemoryError(t.memory, asTrapError("translate", err))
+	t.endCall(ctx, err)
+	if err != nil && t.snapshot != nil && !t.module.IsClosed() {
+		// the module may have failed in the middle of changing its state
+		if restoreErr := t.restoreSnapshot(context.WithoutCancel(ctx)); restoreErr != nil {
+			t.poisonIfTrapped(err)
+			return translationResult{}, errors.Join(err, restoreErr)
+		}
+		t.logger.Debug("memory snapshot restored after failed translation", slog.Any("error", err))
+		return result, err
+	}
+	t.poisonIfTrapped(err)
+	return result, err
+}
+
+// poisonIfTrapped marks the Translator poisoned if err is WASMTrapError,
+// since the module may have trapped in the middle of changing its state.
+func (t *Translator) poisonIfTrapped(err error) {
+	var trap *WASMTrapError
+	if errors.As(err, &trap) {
+		t.poison = trap
+		t.setState(TranslatorPoisoned)
+		t.logger.Error("translator poisoned by WASM trap", slog.Any("error", err))
+	}
+}
+
+// Poisoned returns the error which poisoned the Translator, or nil if it is not poisoned.
+// It is WASMTrapError if the module has trapped, or the error of module restore after a translation
+// aborted with Config.WASMUseContext. Poisoned Translator rejects translations with ErrTranslatorPoisoned,
+// so it must be closed and replaced. Translator with Config.SnapshotMemory enabled restores the snapshot
+// after traps instead.
+func (t *Translator) Poisoned() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.poison
+}
+
+// restore replaces closed module instance with a new one.
+func (t *Translator) restore(ctx context.Context) error {
+	_ = t.module.Close(ctx)
+	return t.instantiate(ctx, t.files.filesBundle())
+}
+
+func (t *Translator) translateInModule(ctx context.Context, requests []TranslationRequest) (translationResult, error) {
+	input, err := gen.NewClassVectorString(t.embindEngine, ctx)
+	if err != nil {
+		return translationResult{}, err
+	}
+	t.objects.track("VectorString", input)
 	defer input.Delete(ctx)
 	options, err := gen.NewClassVectorResponseOptions(t.embindEngine, ctx)
 	if err != nil {
-		return nil, err
+		return translationResult{}, err
 	}
+	t.objects.track("VectorResponseOptions", options)
 	defer options.Delete(ctx)
 	if err := convertToInput(ctx, input, options, requests); err != nil {
-		return nil, err
+		return translationResult{}, err
 	}
 	resp, err := t.svc.Translate(ctx, t.model, input, options)
 	if err != nil {
-		return nil, err
+		return translationResult{}, err
 	}
-	return processResponse(ctx, resp)
+	if resp != nil {
+		t.objects.track("VectorResponse", resp)
+	}
+	return processResponse(ctx, &t.objects, resp)
+}
+
+// ExecutionMode returns the mode used to run the module, which is either ExecutionCompiler or ExecutionInterpreter.
+func (t *Translator) ExecutionMode() ExecutionMode {
+	return t.engine.ExecutionMode()
+}
+
+// memorySize returns the size of WASM linear memory in bytes.
+func (t *Translator) memorySize() uint64 {
+	return uint64(t.module.Memory().Size())
 }
 
-// Close deletes created objects and stops the WASM runtime
+// Close deletes created objects and closes the module instance, along with Engine created by New.
+// It waits for a running call to finish. Following calls fail with ErrClosed, and Close does nothing.
 func (t *Translator) Close(ctx context.Context) error {
-	if err := t.model.Delete(ctx); err != nil {
-		return err
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.State() == TranslatorClosed {
+		return nil
 	}
-	if err := t.svc.Delete(ctx); err != nil {
-		return err
+	t.setState(TranslatorClosed)
+
+	var errs []error
+	// objects of a poisoned or closed module are not deleted, since it may trap again,
+	// and closing module releases them anyway
+	if t.poison == nil && !t.module.IsClosed() {
+		if err := t.objects.releaseInstance(ctx); err != nil {
+			errs = append(errs, asTrapError("close", err))
+		}
+	}
+	if err := t.module.Close(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	t.logger.Debug("translator closed")
+	if t.ownsEngine {
+		errs = append(errs, t.engine.Close(ctx))
 	}
-	return t.wasmRuntime.Close(ctx)
+	return errors.Join(errs...)
 }
 
 func convertToInput(
@@ -277,33 +499,53 @@ func convertToInput(
 	return nil
 }
 
-func processResponse(ctx context.Context, resp embind.ClassBase) ([]string, error) {
+// processResponse reads translated texts of the response and deletes it along with its elements.
+// Created objects are tracked in objects, so they are deleted even if the response is malformed.
+func processResponse(ctx context.Context, objects *embindObjects, resp embind.ClassBase) (translationResult, error) {
 	responseVector, ok := resp.(*gen.ClassVectorResponse)
 	if !ok {
-		return nil, fmt.Errorf("expected response to be a Response vector but got %T", resp)
+		return translationResult{}, fmt.Errorf("expected response to be a Response vector but got %T", resp)
 	}
 	defer responseVector.Delete(ctx)
 	n, err := responseVector.Size(ctx)
 	if err != nil {
-		return nil, err
+		return translationResult{}, err
 	}
-	output := make([]string, 0, n)
+	result := translationResult{outputs: make([]string, 0, n)}
 	for i := uint32(0); i < n; i++ {
-		rawResponse, err := responseVector.Get(ctx, i)
+		translatedText, sentences, err := readResponse(ctx, objects, responseVector, i)
 		if err != nil {
-			return nil, err
-		}
-		response, ok := rawResponse.(*gen.ClassResponse)
-		if !ok {
-			return nil, fmt.Errorf("expected response vector element to be a Response but got %T", rawResponse)
+			return translationResult{}, err
 		}
-		translatedText, err := response.GetTranslatedText(ctx)
-		if err != nil {
-			return nil, err
-		}
-		output = append(output, translatedText)
+		result.outputs = append(result.outputs, translatedText)
+		result.sentences += sentences
+	}
+	return result, nil
+}
+
+// readResponse reads the i-th element of the response vector, which is a copy to be deleted.
+func readResponse(ctx context.Context, objects *embindObjects, responseVector *gen.ClassVectorResponse, i uint32) (string, int, error) {
+	rawResponse, err := responseVector.Get(ctx, i)
+	if err != nil {
+		return "", 0, err
+	}
+	if object, ok := rawResponse.(embind.ClassBase); ok {
+		objects.track("Response", object)
+	}
+	response, ok := rawResponse.(*gen.ClassResponse)
+	if !ok {
+		return "", 0, fmt.Errorf("expected response vector element to be a Response but got %T", rawResponse)
+	}
+	defer response.Delete(ctx)
+	translatedText, err := response.GetTranslatedText(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	sentences, err := response.Size(ctx)
+	if err != nil {
+		return "", 0, err
 	}
-	return output, nil
+	return translatedText, int(sentences), nil
 }
 
 type alignedMemoryInfo struct {
@@ -383,7 +625,9 @@ func enrichAlignedMemoriesBundle(
 		return alignedMemoriesBundle{}, err
 	}
 	// growing module memory to avoid extra allocations
-	growModuleMemory(mod, bundle)
+	if err := growModuleMemory(mod, bundle); err != nil {
+		return alignedMemoriesBundle{}, err
+	}
 
 	// Process model
 	if !bundle.model.isEmpty() {
@@ -485,7 +729,7 @@ func enrichBundleWithSizes(ctx context.Context, bundle alignedMemoriesBundle) (a
 
 const wasmMemoryPageSize = uint32(65536)
 
-func growModuleMemory(mod api.Module, bundle alignedMemoriesBundle) {
+func growModuleMemory(mod api.Module, bundle alignedMemoriesBundle) error {
 	var size uint32
 
 	// Add model size
@@ -509,7 +753,7 @@ func growModuleMemory(mod api.Module, bundle alignedMemoriesBundle) {
 	mem := mod.Memory()
 	availableSize := mem.Size()
 	if availableSize >= size {
-		return
+		return nil
 	}
 
 	requiredSize := size - availableSize
@@ -518,7 +762,10 @@ func growModuleMemory(mod api.Module, bundle alignedMemoriesBundle) {
 	if pages*wasmMemoryPageSize < requiredSize {
 		pages += 1
 	}
-	mem.Grow(pages)
+	if _, ok := mem.Grow(pages); !ok {
+		return fmt.Errorf("%w: model files take %d bytes, while memory is %d bytes", ErrOutOfMemory, size, availableSize)
+	}
+	return nil
 }
 
 func getAlignedMemoryByteView(ctx context.Context, memory *gen.ClassAlignedMemory) ([]int8, error) {
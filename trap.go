@@ -0,0 +1,141 @@
+package gobergamot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tetratelabs/wazero/sys"
+
+	"github.com/xxnuo/gobergamot/internal/wasm"
+)
+
+// ErrTranslatorPoisoned is returned by Translator calls after the module has trapped,
+// since its state may be inconsistent, or has failed to be restored. It wraps the error
+// which poisoned the Translator, see Translator.Poisoned.
+var ErrTranslatorPoisoned = errors.New("translator is poisoned")
+
+// WASMTrapError is returned if the Bergamot module function was aborted: by a trap like a Bergamot abort
+// or an out of bounds memory access, by a failed host function like a GemmBackend one, or by the module exit.
+type WASMTrapError struct {
+	// Op is the Translator operation which failed, e.g. "translate"
+	Op string
+	// HostFunc is the name of the failed host function, e.g. "wasm_gemm.int8_prepare_a".
+	// It is empty if the module trapped itself.
+	HostFunc string
+	// Reason describes the trap, e.g. "unreachable" for Bergamot aborts
+	Reason string
+	// Stack lists WASM functions being called at the moment of the trap starting from the innermost one.
+	// wazero keeps 30 frames at most.
+	Stack []WASMFrame
+	// Err is the original error returned by wazero
+	Err error
+}
+
+// WASMFrame is a WASM stack trace frame.
+type WASMFrame struct {
+	// Function is the function name with its WASM signature, e.g. "wasm_gemm.int8_prepare_a(i32,f32,f32,i32,i32,i32)"
+	Function string
+	// Sources are C++ source locations of the frame resolved from DWARF, so they are available
+	// with gobergamot_debug build tag only. Inlined functions add a location each.
+	Sources []string
+}
+
+func (e *WASMTrapError) Error() string {
+	var b strings.Builder
+	b.WriteString("wasm trap in ")
+	b.WriteString(e.Op)
+	if e.HostFunc != "" {
+		b.WriteString(" calling ")
+		b.WriteString(e.HostFunc)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Reason)
+	if len(e.Stack) > 0 {
+		b.WriteString("\nwasm stack trace:")
+		for _, frame := range e.Stack {
+			b.WriteString("\n\t")
+			b.WriteString(frame.Function)
+			for _, source := range frame.Sources {
+				b.WriteString("\n\t\t")
+				b.WriteString(source)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (e *WASMTrapError) Unwrap() error {
+	return e.Err
+}
+
+// wazero formats errors of aborted functions as the reason followed by the stack trace.
+// See internal/wasmdebug in wazero sources.
+const (
+	wasmStackTraceHeader = "\nwasm stack trace:\n"
+	wasmErrorPrefix      = "wasm error: "
+	wasmRecoveredSuffix  = " (recovered by wazero)"
+)
+
+// asTrapError converts err into WASMTrapError if it is caused by an aborted module function.
+// Other errors, including context cancellation, are returned as is.
+func asTrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var trap *WASMTrapError
+	if errors.As(err, &trap) {
+		return err
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case 0, sys.ExitCodeContextCanceled, sys.ExitCodeDeadlineExceeded:
+			// the module was closed by Engine or upon context cancellation
+			return err
+		}
+		return &WASMTrapError{Op: op, Reason: fmt.Sprintf("module exited with code %d", exitErr.ExitCode()), Err: err}
+	}
+
+	msg := err.Error()
+	reason, stack, ok := strings.Cut(msg, wasmStackTraceHeader)
+	if !ok {
+		return err
+	}
+	trap = &WASMTrapError{Op: op, Stack: parseWASMStack(stack), Err: err}
+
+	var hostErr *wasm.HostFuncError
+	switch {
+	case errors.As(err, &hostErr):
+		trap.HostFunc = hostErr.Module + "." + hostErr.Func
+		trap.Reason = hostErr.Err.Error()
+	case strings.Contains(reason, wasmErrorPrefix):
+		trap.Reason = reason[strings.LastIndex(reason, wasmErrorPrefix)+len(wasmErrorPrefix):]
+	default:
+		// Go runtime error in a host function
+		trap.Reason = strings.TrimSuffix(reason, wasmRecoveredSuffix)
+	}
+	return trap
+}
+
+func parseWASMStack(stack string) []WASMFrame {
+	// wazero appends Go stack trace after an empty line if a host function caused a Go runtime error
+	stack, _, _ = strings.Cut(stack, "\n\n")
+
+	var frames []WASMFrame
+	for _, line := range strings.Split(stack, "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t\t"):
+			if len(frames) > 0 {
+				frame := &frames[len(frames)-1]
+				frame.Sources = append(frame.Sources, strings.TrimPrefix(line, "\t\t"))
+			}
+		case strings.HasPrefix(line, "\t..."):
+			// marks omitted frames
+		case strings.HasPrefix(line, "\t"):
+			frames = append(frames, WASMFrame{Function: strings.TrimPrefix(line, "\t")})
+		}
+	}
+	return frames
+}
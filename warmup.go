@@ -0,0 +1,69 @@
+package gobergamot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// DefaultWarmupSamples provides requests used to warm up translators if no samples are given.
+// They cover short and long sentences and HTML processing.
+func DefaultWarmupSamples() []TranslationRequest {
+	return []TranslationRequest{
+		{Text: "Hello, World!"},
+		{Text: "Computers have become an integral part of our daily lives. They have a great impact on the way we live, work, and communicate."},
+		{Text: "<p>This is a test of <b>HTML</b> translation.</p>", Options: TranslationOptions{HTML: true}},
+	}
+}
+
+// Warmup translates samples to make WASM module allocate its memory and workspaces,
+// so the following translations are as fast as steady state ones.
+// If no samples are given, DefaultWarmupSamples are used.
+func (t *Translator) Warmup(ctx context.Context, samples ...TranslationRequest) error {
+	if len(samples) == 0 {
+		samples = DefaultWarmupSamples()
+	}
+	// samples are translated one by one to go through the same paths as single requests
+	for i := range samples {
+		if _, err := t.Translate(ctx, samples[i]); err != nil {
+			return fmt.Errorf("failed to translate warmup sample %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Ready returns a channel which is closed when all workers of the Pool are ready to translate,
+// i.e. when they have completed warmup if PoolConfig.Warmup is enabled.
+func (p *Pool) Ready() <-chan struct{} {
+	return p.ready
+}
+
+// WaitReady waits until all workers of the Pool are ready and returns warmup errors if any.
+func (p *Pool) WaitReady(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ready:
+	}
+	p.warmupMu.Lock()
+	defer p.warmupMu.Unlock()
+	return p.warmupErr
+}
+
+func (p *Pool) warmupWorker(w *poolWorker) {
+	defer p.warming.Done()
+	if err := p.warmup(context.Background(), w.translator); err != nil {
+		p.logger.Warn("worker warmup failed", slog.Int("worker", w.index), slog.Any("error", err))
+		p.warmupMu.Lock()
+		p.warmupErr = errors.Join(p.warmupErr, err)
+		p.warmupMu.Unlock()
+	}
+}
+
+func (p *Pool) warmup(ctx context.Context, translator *Translator) error {
+	if !p.cfg.Warmup {
+		return nil
+	}
+	return translator.Warmup(ctx, p.cfg.WarmupSamples...)
+}